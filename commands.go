@@ -0,0 +1,270 @@
+package main
+
+import (
+	. "./rbgo"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+func watchFlags(fs *flag.FlagSet, o *options) {
+	fs.BoolVar(&o.run, "run", false, "run the built binaries and restart them after rebuilds")
+	fs.DurationVar(&o.grace, "grace", DefaultGracePeriod, "how long a binary may take to exit on SIGTERM before it's killed")
+	fs.DurationVar(&o.debounce, "debounce", 0, "quiet period before rebuilding, overrides the config")
+	fs.DurationVar(&o.maxWait, "max-wait", 0, "longest delay of a rebuild while changes keep coming, overrides the config")
+	fs.StringVar(&o.backend, "watcher", "", "how changes are detected, `fsnotify` or poll, overrides the config")
+	fs.DurationVar(&o.pollInterval, "poll-interval", 0, "interval of the poll watcher, overrides the config")
+	fs.BoolVar(&o.test, "test", false, "run the tests of updated packages")
+	fs.BoolVar(&o.testReferrers, "test-referrers", false, "run the tests of the packages importing updated packages too")
+	fs.BoolVar(&o.json, "json", false, "write the events as JSON lines to stdout, the build and binary output to stderr")
+}
+
+func runWatch(o *options) int {
+	// the watcher reports the scan errors to its observer
+	ws, err := o.scan()
+	if err != nil {
+		o.errorf("%s", err)
+		return exitFailure
+	}
+	watcher := Watcher{
+		Workspace:     ws,
+		Workers:       o.jobs,
+		Supervise:     o.run,
+		GracePeriod:   o.grace,
+		Debounce:      o.debounce,
+		MaxWait:       o.maxWait,
+		Backend:       o.backend,
+		PollInterval:  o.pollInterval,
+		Test:          o.test || o.testReferrers,
+		TestReferrers: o.testReferrers,
+	}
+	if o.targets != "" {
+		watcher.Select = o.selects
+	}
+	if o.json {
+		watcher.Observer = &JSONObserver{W: o.stdout}
+		watcher.Log = o.stderr
+	} else {
+		watcher.Observer = &ConsoleObserver{W: o.stdout, Verbose: o.verbose}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := watcher.Watch(ctx); err != nil {
+		o.errorf("%s", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func runBuild(o *options) int {
+	ws, err := o.open()
+	if err != nil {
+		o.errorf("%s", err)
+		return exitFailure
+	}
+	report, err := ws.Package.BuildMatrix(o.selected(ws), ws.Platforms, o.jobs)
+	if err != nil {
+		o.errorf("%s", err)
+		return exitFailure
+	}
+	reported := map[*Package]bool{}
+	for _, r := range report.Results {
+		name := r.Task.PackageName
+		if r.Task.Platform != (Platform{}) {
+			name = fmt.Sprintf("%s (%s)", name, r.Task.Platform)
+		}
+		if r.Err != nil {
+			o.errorf("%s: %s", name, r.Err)
+			reported[r.Task.Package] = true
+		} else if o.verbose {
+			fmt.Fprintf(o.stdout, "Built: %s\n", r.Task.ObjectPath)
+		}
+	}
+	for _, pkg := range sortPackages(report.Missing) {
+		if reported[pkg] {
+			continue
+		}
+		o.errorf("%s: Package not found '%s'", pkg.FullName, strings.Join(pkg.MissingImports, "', '"))
+	}
+	if len(report.Platforms) > 1 {
+		for _, p := range report.Platforms {
+			fmt.Fprintf(o.stdout, "%s: %s\n", p.Platform, p)
+		}
+	}
+	fmt.Fprintln(o.stdout, report)
+	if !report.OK() {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func runTest(o *options) int {
+	ws, err := o.open()
+	if err != nil {
+		o.errorf("%s", err)
+		return exitFailure
+	}
+	status := exitOK
+	for _, pkg := range sortPackages(ws.Package.TestTargets(o.selected(ws), false)) {
+		task, err := (&TaskFactory{Package: ws.Package}).New(pkg.WatchPath)
+		if err != nil {
+			o.errorf("%s", err)
+			return exitFailure
+		}
+		r := task.Test()
+		switch {
+		case r.Err != nil:
+			o.errorf("%s: %s", pkg.FullName, r.Err)
+			status = exitFailure
+		case r.Passed:
+			fmt.Fprintf(o.stdout, "PASS: %s (%v)\n", pkg.FullName, r.Duration)
+			if o.verbose {
+				fmt.Fprint(o.stdout, r.Output)
+			}
+		default:
+			fmt.Fprintf(o.stdout, "FAIL: %s (%v)\n%s", pkg.FullName, r.Duration, r.Output)
+			status = exitFailure
+		}
+	}
+	return status
+}
+
+func runList(o *options) int {
+	ws, err := o.open()
+	if err != nil {
+		o.errorf("%s", err)
+		return exitFailure
+	}
+	for _, pkg := range sortPackages(o.selected(ws)) {
+		if !o.verbose {
+			fmt.Fprintln(o.stdout, pkg.FullName)
+			continue
+		}
+		kind := "package"
+		if pkg.IsCommand {
+			kind = "command"
+		} else if pkg.InVendor {
+			kind = "vendor"
+		}
+		fmt.Fprintf(o.stdout, "%s\t%s\t%s\t%s\n", pkg.FullName, kind, pkg.WatchPath, pkg.ObjectPath)
+	}
+	return exitOK
+}
+
+func runStatus(o *options) int {
+	ws, err := o.open()
+	if err != nil {
+		o.errorf("%s", err)
+		return exitFailure
+	}
+	factory := TaskFactory{Package: ws.Package}
+	for _, pkg := range sortPackages(o.selected(ws)) {
+		if len(pkg.MissingImports) > 0 {
+			fmt.Fprintf(o.stdout, "missing  %s (%s)\n", pkg.FullName, strings.Join(pkg.MissingImports, ", "))
+			continue
+		}
+		for _, platform := range targetPlatforms(ws) {
+			task, err := factory.NewPlatform(pkg.WatchPath, platform)
+			if err != nil {
+				o.errorf("%s", err)
+				return exitFailure
+			}
+			name := pkg.FullName
+			if platform != (Platform{}) {
+				name = fmt.Sprintf("%s (%s)", name, platform)
+			}
+			switch {
+			case task.Stale():
+				fmt.Fprintf(o.stdout, "stale    %s\n", name)
+			case o.verbose:
+				fmt.Fprintf(o.stdout, "ok       %s\n", name)
+			}
+		}
+	}
+	return exitOK
+}
+
+func runGraph(o *options) int {
+	ws, err := o.open()
+	if err != nil {
+		o.errorf("%s", err)
+		return exitFailure
+	}
+	fmt.Fprintln(o.stdout, "digraph imports {")
+	for _, pkg := range sortPackages(o.selected(ws)) {
+		fmt.Fprintf(o.stdout, "\t%q;\n", pkg.FullName)
+		for _, imp := range pkg.Imports {
+			// packages out of the workspace only with -v
+			if ws.Package.FindByImportName(imp) == nil && !o.verbose {
+				continue
+			}
+			fmt.Fprintf(o.stdout, "\t%q -> %q;\n", pkg.FullName, imp)
+		}
+	}
+	fmt.Fprintln(o.stdout, "}")
+	return exitOK
+}
+
+func runClean(o *options) int {
+	ws, err := o.open()
+	if err != nil {
+		o.errorf("%s", err)
+		return exitFailure
+	}
+	files := []string{}
+	seen := map[string]bool{}
+	for _, pkg := range o.selected(ws) {
+		for _, platform := range targetPlatforms(ws) {
+			if file := pkg.PlatformObjectPath(platform); !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	if o.targets == "" {
+		files = append(files, ws.ManifestPath())
+	}
+	sort.Strings(files)
+	status := exitOK
+	for _, file := range files {
+		if err := os.Remove(file); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			o.errorf("%s", err)
+			status = exitFailure
+			continue
+		}
+		ws.Package.Manifest.Delete(file)
+		if o.verbose {
+			fmt.Fprintf(o.stdout, "Remove: %s\n", file)
+		}
+	}
+	if o.targets != "" {
+		if err := ws.Package.Manifest.Save(); err != nil {
+			o.errorf("%s", err)
+			status = exitFailure
+		}
+	}
+	return status
+}
+
+// targetPlatforms returns the platforms the workspace is built for, the
+// default platform if none is configured.
+func targetPlatforms(ws *Workspace) []Platform {
+	if len(ws.Platforms) == 0 {
+		return []Platform{{}}
+	}
+	return ws.Platforms
+}
+
+func sortPackages(pkgs []*Package) []*Package {
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].FullName < pkgs[j].FullName
+	})
+	return pkgs
+}
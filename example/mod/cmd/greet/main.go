@@ -0,0 +1,11 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kai-zoa/example/greeting"
+)
+
+func main() {
+	fmt.Println(greeting.Greeting("rbgo"))
+}
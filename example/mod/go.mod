@@ -0,0 +1,3 @@
+module github.com/kai-zoa/example
+
+go 1.16
@@ -0,0 +1,11 @@
+package greeting
+
+import (
+	"strings"
+
+	"github.com/kai-zoa/example/hello"
+)
+
+func Greeting(name string) string {
+	return strings.Join([]string{hello.Hello(), name}, ", ")
+}
@@ -0,0 +1,5 @@
+package hello
+
+func Hello() string {
+	return "Hello"
+}
@@ -0,0 +1,9 @@
+package hello
+
+import "testing"
+
+func TestHello(t *testing.T) {
+	if a, e := Hello(), "Hello"; a != e {
+		t.Errorf("actual: %v\nexpect: %v", a, e)
+	}
+}
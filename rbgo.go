@@ -2,19 +2,209 @@ package main
 
 import (
 	. "./rbgo"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
 )
 
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(o *options) int
+	flags   func(fs *flag.FlagSet, o *options)
+}
+
+var commands = []*command{
+	{name: "watch", summary: "build on start and rebuild on every change", run: runWatch, flags: watchFlags},
+	{name: "build", summary: "build the stale packages once", run: runBuild},
+	{name: "test", summary: "run the tests of the packages", run: runTest},
+	{name: "list", summary: "list the packages of the workspace", run: runList},
+	{name: "status", summary: "show which packages are stale", run: runStatus},
+	{name: "graph", summary: "print the import graph in DOT format", run: runGraph},
+	{name: "clean", summary: "remove built objects and the build manifest", run: runClean},
+}
+
+// options are the flags every command accepts.
+type options struct {
+	dir     string
+	config  string
+	cache   string
+	targets string
+	verbose bool
+	jobs    int
+	stdout  io.Writer
+	stderr  io.Writer
+
+	// watch
+	run           bool
+	grace         time.Duration
+	debounce      time.Duration
+	maxWait       time.Duration
+	backend       string
+	pollInterval  time.Duration
+	test          bool
+	testReferrers bool
+	json          bool
+}
+
 func main() {
-	ws, err := NewWorkspace(".")
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			usage(os.Stdout)
+			return exitOK
+		}
+	}
+	// `rbgo` alone watches the current directory
+	name := "watch"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c.execute(args)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "rbgo: unknown command %q\n", name)
+	usage(os.Stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Continuous rebuild your go binary objects.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  rbgo <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run `rbgo <command> -help` for the flags of a command.")
+}
+
+func (c *command) execute(args []string) int {
+	o := &options{stdout: os.Stdout, stderr: os.Stderr}
+	fs := flag.NewFlagSet("rbgo "+c.name, flag.ContinueOnError)
+	fs.SetOutput(o.stderr)
+	fs.StringVar(&o.dir, "C", ".", "workspace `dir`")
+	fs.StringVar(&o.config, "config", "", "config `file` read instead of .rbgo.yml in the workspace")
+	fs.StringVar(&o.cache, "cache", "", "build cache `dir` instead of the configured one, `off` to disable it")
+	fs.StringVar(&o.targets, "target", "", "comma separated import path `patterns` to select, `...` matches any string")
+	fs.BoolVar(&o.verbose, "v", false, "verbose output")
+	fs.IntVar(&o.jobs, "j", runtime.GOMAXPROCS(0), "number of parallel builds")
+	if c.flags != nil {
+		c.flags(fs, o)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: rbgo %s [flags]\n\n%s.\n\nFlags:\n", c.name, strings.ToUpper(c.summary[:1])+c.summary[1:])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return exitOK
+	} else if err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(o.stderr, "rbgo %s: unexpected arguments %v\n", c.name, fs.Args())
+		fs.Usage()
+		return exitUsage
+	}
+	if o.jobs < 1 {
+		fmt.Fprintf(o.stderr, "rbgo %s: -j must be positive\n", c.name)
+		return exitUsage
+	}
+	return c.run(o)
+}
+
+// open opens and scans the workspace the options point at, printing the
+// packages which couldn't be scanned.
+func (o *options) open() (*Workspace, error) {
+	ws, err := o.scan()
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
+	}
+	for _, err := range ws.ScanErrors {
+		o.errorf("%s", err)
+	}
+	return ws, nil
+}
+
+// scan opens and scans the workspace, leaving its ScanErrors to the caller.
+func (o *options) scan() (*Workspace, error) {
+	ws, err := NewWorkspaceConfig(o.dir, o.config)
+	if err != nil {
+		return nil, err
+	}
+	if o.cache != "" {
+		ws.SetCacheDir(o.cache)
 	}
 	if err := ws.Init(); err != nil {
-		fmt.Println(err)
+		return nil, err
+	}
+	return ws, nil
+}
+
+// selected returns the packages matching -target, or every package.
+func (o *options) selected(ws *Workspace) []*Package {
+	pkgs := []*Package{}
+	for _, pkg := range ws.Package.All() {
+		if o.selects(pkg) {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs
+}
+
+// selects reports whether the package matches -target.
+func (o *options) selects(pkg *Package) bool {
+	if o.targets == "" {
+		return true
 	}
-	watcher := Watcher{Workspace:ws}
-	if err := watcher.Watch(); err != nil {
-		fmt.Println(err)
+	for _, pattern := range strings.Split(o.targets, ",") {
+		if matchPattern(strings.TrimSpace(pattern), pkg.FullName) {
+			return true
+		}
 	}
+	return false
+}
+
+// matchPattern reports whether the import path matches the pattern, in which
+// `...` matches any string as in `go list`.
+func matchPattern(pattern, name string) bool {
+	parts := strings.Split(pattern, "...")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i == -1 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
+
+func (o *options) errorf(format string, a ...interface{}) {
+	fmt.Fprintf(o.stderr, "Error: "+format+"\n", a...)
 }
@@ -1,14 +1,19 @@
 package rbgo
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"runtime"
 	"io"
 	"io/ioutil"
-	"errors"
+	"sort"
+	"time"
 )
 
 type TaskFactory struct {
@@ -16,25 +21,55 @@ type TaskFactory struct {
 }
 
 func (f *TaskFactory) New(dirName string) (*Task, error) {
-	pkg := f.Package.FindByPath(dirName)
+	return f.NewPlatform(dirName, Platform{})
+}
+
+// NewPlatform returns the task building the package for the platform.
+func (f *TaskFactory) NewPlatform(dirName string, platform Platform) (*Task, error) {
+	defer f.Package.rlock()()
+	pkg := f.Package.findByPath(dirName)
 	if pkg == nil {
 		return nil, fmt.Errorf("Package not found: `%s`", dirName)
 	}
-	return newJob(pkg, f.Package), nil
+	return newPlatformJob(pkg, f.Package, platform), nil
 }
 
 func newJob(pkg *Package, r *PackageRepository) *Task {
+	return newPlatformJob(pkg, r, Platform{})
+}
+
+func newPlatformJob(pkg *Package, r *PackageRepository, platform Platform) *Task {
+	return newRunJob(pkg, r, platform, nil)
+}
+
+// newRunJob returns the task of a build run sharing the stamps.
+func newRunJob(pkg *Package, r *PackageRepository, platform Platform, stamps *stampCache) *Task {
 	return &Task{
 		PackageName: pkg.FullName,
 		SourcePath: pkg.SourcePath,
-		ObjectPath: pkg.ObjectPath,
+		ObjectPath: pkg.PlatformObjectPath(platform),
+		Platform: platform,
 		Package: pkg,
 		repo: r,
+		stamps: stamps,
+	}
+}
+
+// relativePath returns path relative to the directory `go build` runs in.
+func relativePath(dir, path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(dir, absPath)
+	if err != nil {
+		return absPath
 	}
+	return filepath.ToSlash(rel)
 }
 
 func normalizePath(s string) string {
-	if s == "" {
+	if s == "" || s == "." {
 		return "."
 	}
 	// FIXME for Windows
@@ -44,41 +79,90 @@ func normalizePath(s string) string {
 	return fmt.Sprintf("./%s", s)
 }
 
+// BuildOptions are the flags and environment variables `go build` runs with.
+type BuildOptions struct {
+	// Flags are passed as is, before the flags of the other options.
+	Flags    []string
+	Tags     []string
+	// Race and TrimPath are off if nil, set to override the workspace's.
+	Race     *bool
+	GCFlags  string
+	// LDFlags is a template of StampData, e.g. `-X main.commit={{.Commit}}`,
+	// linking commands only.
+	LDFlags  string
+	TrimPath *bool
+	Env      map[string]string
+}
+
 type Task struct {
 	PackageName string
 	SourcePath  string
 	ObjectPath  string
+	// Platform is the target of the build, the default target if zero.
+	Platform    Platform
 	Package     *Package
+	// Log receives the command line and output of `go build`, os.Stdout if nil.
+	Log         io.Writer
 	repo        *PackageRepository
+	// stamps are the git lookups of the build run, nil outside of one
+	stamps      *stampCache
 }
 
-func (t *Task) Build() error {
+// arguments returns the `go` arguments but the output and the source, the
+// ldflags template unexpanded.
+func (t *Task) arguments() []string {
+	arguments := []string{"build"}
+	if o := t.Package.Options; o != nil {
+		arguments = append(arguments, o.flags(t.Package.IsCommand, o.LDFlags)...)
+	}
+	return arguments
+}
 
-	object := normalizePath(t.ObjectPath)
-	source := normalizePath(t.SourcePath)
+func (t *Task) command(ctx context.Context) (*exec.Cmd, error) {
+	object := normalizePath(relativePath(t.Package.WorkDir, t.ObjectPath))
+	source := normalizePath(relativePath(t.Package.WorkDir, t.SourcePath))
 	arguments := []string{"build"}
+	if o := t.Package.Options; o != nil {
+		ldflags, err := t.ldflags(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, o.flags(t.Package.IsCommand, ldflags)...)
+	}
 	arguments = append(arguments, ([]string{"-o", object, source})...)
-	command := exec.Command("go", arguments...)
+	command := exec.CommandContext(ctx, "go", arguments...)
 	command.Dir = t.Package.WorkDir
-	// Set GOPATH
-	goPath := ""
-	env := make([]string, 0, len(os.Environ()))
-	for _, e := range os.Environ() {
-		if strings.HasPrefix(e, "GOPATH=") {
-			goPath = strings.Split(e, "=")[1]
-		} else {
-			env = append(env, e)
-		}
+	command.Env = t.environ()
+	return command, nil
+}
+
+func (t *Task) Build() error {
+	return t.BuildContext(context.Background())
+}
+
+// BuildContext builds like Build, killing `go build` when ctx is done.
+func (t *Task) BuildContext(ctx context.Context) error {
+
+	// the package may be rescanned while `go build` runs, read it up front
+	unlock := t.repo.rlock()
+	command, err := t.command(ctx)
+	// hash the inputs before building so changes made meanwhile stay stale
+	hash := ""
+	if err == nil && (t.manifest() != nil || t.cache() != nil) {
+		hash, err = t.inputHash()
 	}
-	sep := ":"
-	if runtime.GOOS == "windows" {
-		sep = ";"
+	unlock()
+	if err != nil {
+		return err
+	}
+	if c := t.cache(); c != nil {
+		if found, err := c.Get(hash, t.ObjectPath); err != nil {
+			return err
+		} else if found {
+			fmt.Fprintf(t.log(), "Restore: %s\n", t.ObjectPath)
+			return t.record(hash)
+		}
 	}
-	goPath = strings.Join([]string{t.Package.WorkDir, goPath}, sep)
-	//fmt.Println(t.Package.WorkDir)
-	//fmt.Printf("%v\n", arguments)
-	env = append(env, fmt.Sprintf("%s=%s", "GOPATH", goPath))
-	command.Env = env
 
 	//
 	stderr, err := command.StderrPipe()
@@ -89,24 +173,277 @@ func (t *Task) Build() error {
 	if err != nil {
 		return err
 	}
-	fmt.Println(strings.Join(command.Args, " "))
+	if t.Platform != (Platform{}) {
+		fmt.Fprintf(t.log(), "GOOS=%s GOARCH=%s ", t.Platform.GOOS, t.Platform.GOARCH)
+	}
+	fmt.Fprintln(t.log(), strings.Join(command.Args, " "))
 	if err := command.Start(); err != nil {
 		return err
 	}
 
-	io.Copy(os.Stdout, stdout)
+	io.Copy(t.log(), stdout)
 	errBuf, _ := ioutil.ReadAll(stderr)
 
 	//fmt.Printf("waiting for `go %v`\n", arguments)
 	if err := command.Wait(); err != nil {
-		return errors.New(string(errBuf))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return newBuildError(t, command.Dir, errBuf, err)
 	}
 
+	if c := t.cache(); c != nil {
+		if err := c.Put(hash, t.ObjectPath); err != nil {
+			return err
+		}
+	}
+	return t.record(hash)
+}
+
+func (t *Task) log() io.Writer {
+	if t.Log == nil {
+		return os.Stdout
+	}
+	return t.Log
+}
+
+func (t *Task) record(hash string) error {
+	if m := t.manifest(); m != nil {
+		m.Set(t.ObjectPath, hash)
+		return m.Save()
+	}
 	return nil
 }
 
+func (t *Task) manifest() *Manifest {
+	if t.repo == nil {
+		return nil
+	}
+	return t.repo.Manifest
+}
+
+func (t *Task) cache() *Cache {
+	if t.repo == nil {
+		return nil
+	}
+	return t.repo.Cache
+}
+
+// InputHash hashes everything the object is built from: the package's
+// sources, the build flags, the target platform and Go environment, the
+// module's requirements and the archives of the packages it transitively
+// imports. It doesn't depend on
+// where the object is written, so it keys the build cache as well.
+func (t *Task) InputHash() (string, error) {
+	defer t.repo.rlock()()
+	return t.inputHash()
+}
+
+func (t *Task) inputHash() (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "package %s %s\n", t.PackageName, t.Package.Name)
+	fmt.Fprintf(h, "platform %s\n", t.Platform)
+	fmt.Fprintf(h, "arguments %q\n", t.arguments())
+	for _, e := range t.goEnviron() {
+		if strings.HasPrefix(e, "GO") || strings.HasPrefix(e, "CGO_") {
+			fmt.Fprintf(h, "env %q\n", e)
+		}
+	}
+	if t.Package.Options != nil {
+		for _, key := range t.Package.Options.envKeys() {
+			fmt.Fprintf(h, "option env %q\n", key+"="+t.Package.Options.Env[key])
+		}
+		// the build time isn't an input, the binary would be always stale
+		if stamp := t.stampData(time.Time{}); stamp.Commit != "" || stamp.Version != "" {
+			fmt.Fprintf(h, "stamp %q %q\n", stamp.Commit, stamp.Version)
+		}
+	}
+	if t.Package.Module != nil {
+		t.Package.Module.writeInputs(h)
+	}
+	for _, file := range t.sourceFiles() {
+		sum, err := hashFile(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "file %s %s\n", filepath.Base(file), sum)
+	}
+	deps := []*Package{}
+	t.transitiveImports(t.Package, map[string]bool{t.ObjectPath: true}, &deps)
+	sort.Slice(deps, func(i, j int) bool {
+		return deps[i].ObjectPath < deps[j].ObjectPath
+	})
+	for _, dep := range deps {
+		// a missing archive is hashed as such, it's stale itself
+		sum, _ := hashFile(dep.PlatformObjectPath(t.Platform))
+		fmt.Fprintf(h, "import %s %s\n", dep.FullName, sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sourceFiles returns the sources of the package built for the platform. The
+// package is scanned for the default platform, the sources it excluded may be
+// built for another.
+func (t *Task) sourceFiles() []string {
+	if t.Platform.IsDefault() {
+		return t.Package.Files
+	}
+	ctxt := t.Platform.buildContext(t.Package.BuildContext)
+	files := []string{}
+	for _, file := range append(append([]string{}, t.Package.Files...), t.Package.ExcludedFiles...) {
+		if !IsGoSource(file) {
+			continue
+		}
+		if match, err := ctxt.MatchFile(filepath.Dir(file), filepath.Base(file)); err != nil || match {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func (t *Task) transitiveImports(pkg *Package, visited map[string]bool, deps *[]*Package) {
+	if t.repo == nil {
+		return
+	}
+	for _, imp := range t.repo.objectImports(pkg) {
+		if visited[imp.ObjectPath] {
+			continue
+		}
+		visited[imp.ObjectPath] = true
+		*deps = append(*deps, imp)
+		t.transitiveImports(imp, visited, deps)
+	}
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// environ returns the Go environment with the variables of the options and
+// the platform set.
+func (t *Task) environ() []string {
+	vars := map[string]string{}
+	if t.Package.Options != nil {
+		for key, value := range t.Package.Options.Env {
+			vars[key] = value
+		}
+	}
+	if t.Platform != (Platform{}) {
+		vars["GOOS"], vars["GOARCH"] = t.Platform.GOOS, t.Platform.GOARCH
+	}
+	if len(vars) == 0 {
+		return t.goEnviron()
+	}
+	env := []string{}
+	for _, e := range t.goEnviron() {
+		if _, found := vars[strings.SplitN(e, "=", 2)[0]]; !found {
+			env = append(env, e)
+		}
+	}
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, fmt.Sprintf("%s=%s", key, vars[key]))
+	}
+	return env
+}
+
+func (o *BuildOptions) envKeys() []string {
+	keys := make([]string, 0, len(o.Env))
+	for key := range o.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (t *Task) goEnviron() []string {
+	env := make([]string, 0, len(os.Environ())+2)
+	if t.Package.Module != nil {
+		for _, e := range os.Environ() {
+			if !strings.HasPrefix(e, "GO111MODULE=") {
+				env = append(env, e)
+			}
+		}
+		return append(env, "GO111MODULE=on")
+	}
+	// Set GOPATH
+	goPath := ""
+	for _, e := range os.Environ() {
+		if strings.HasPrefix(e, "GOPATH=") {
+			goPath = strings.Split(e, "=")[1]
+		} else if !strings.HasPrefix(e, "GO111MODULE=") {
+			env = append(env, e)
+		}
+	}
+	sep := ":"
+	if runtime.GOOS == "windows" {
+		sep = ";"
+	}
+	goPath = strings.Join([]string{t.Package.WorkDir, goPath}, sep)
+	env = append(env, fmt.Sprintf("%s=%s", "GOPATH", goPath))
+	return append(env, "GO111MODULE=off")
+}
+
+// Stale reports whether the object is missing or its inputs changed since it
+// was built. Without a manifest the object is stale when it's older than the
+// package's sources or any archive the package transitively imports.
+func (t *Task) Stale() bool {
+	defer t.repo.rlock()()
+	return t.stale()
+}
+
+func (t *Task) stale() bool {
+	fi, err := os.Stat(t.ObjectPath)
+	if err != nil {
+		return true
+	}
+	if m := t.manifest(); m != nil {
+		hash, err := t.inputHash()
+		return err != nil || m.Get(t.ObjectPath) != hash
+	}
+	if fi.ModTime().Before(t.Package.ModTime) {
+		return true
+	}
+	return t.newerImport(t.Package, fi.ModTime(), map[*Package]bool{})
+}
+
+func (t *Task) newerImport(pkg *Package, modTime time.Time, visited map[*Package]bool) bool {
+	if t.repo == nil {
+		return false
+	}
+	for _, name := range pkg.Imports {
+		imp := t.repo.findByImportName(name)
+		if imp == nil || visited[imp] {
+			continue
+		}
+		visited[imp] = true
+		if fi, err := os.Stat(imp.PlatformObjectPath(t.Platform)); err == nil && fi.ModTime().After(modTime) {
+			return true
+		}
+		if t.newerImport(imp, modTime, visited) {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *Task) FindDepends() (*Task, error) {
-	dep, err := t.findDepends(t.Package)
+	defer t.repo.rlock()()
+	dep, err := t.findDepends(t.Package, map[*Package]bool{})
 	if err != nil {
 		return nil, err
 	}
@@ -117,16 +454,17 @@ func (t *Task) FindDepends() (*Task, error) {
 	return nil, nil
 }
 
-func (t *Task) findDepends(pkg *Package) (*Package, error) {
+func (t *Task) findDepends(pkg *Package, visited map[*Package]bool) (*Package, error) {
 	if len(pkg.MissingImports) > 0 {
 		return nil, fmt.Errorf("Package not found '%s'", pkg.MissingImports[0])
 	}
+	visited[pkg] = true
 	for _, name := range pkg.Imports {
-		imp := t.repo.FindByImportName(name)
-		if imp == nil {
+		imp := t.repo.findByImportName(name)
+		if imp == nil || visited[imp] {
 			continue
 		}
-		dep, err := t.findDepends(imp)
+		dep, err := t.findDepends(imp, visited)
 		if err != nil {
 			return nil, err
 		}
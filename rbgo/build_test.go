@@ -3,7 +3,10 @@ package rbgo
 import (
 	"testing"
 	"regexp"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 func TestTask_Build(t *testing.T) {
@@ -85,3 +88,77 @@ func TestTask_Build(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestTask_Build_Command(t *testing.T) {
+	w, err := NewWorkspace("../example/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.CacheDir = t.TempDir()
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	factory := TaskFactory{Package: w.Package}
+	task, err := factory.New("../example/mod/cmd/greet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := task.Stale(), true; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if err := task.Build(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(task.ObjectPath)
+	if a, e := task.Stale(), false; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	// rebuilding an import makes the command stale
+	dep, err := factory.New("../example/mod/greeting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := dep.Build(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dep.ObjectPath)
+	if a, e := task.Stale(), true; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestTask_Stale_Module(t *testing.T) {
+	ws := newTempModule(t, map[string]string{
+		"hello/hello.go": "package hello\n\nfunc Hello() string { return \"hello\" }\n",
+	})
+	factory := TaskFactory{Package: ws.Package}
+	task, err := factory.New(filepath.Join(ws.root, "hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if a, e := task.Stale(), false; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	// bumping a requirement makes the object stale
+	mod := filepath.Join(ws.root, ModFileName)
+	data, err := ioutil.ReadFile(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, "\nrequire golang.org/x/sys v0.13.0\n"...)
+	if err := ioutil.WriteFile(mod, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if a, e := task.Stale(), true; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
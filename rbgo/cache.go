@@ -0,0 +1,86 @@
+package rbgo
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Cache is a content addressed store of build outputs keyed by the input
+// hash they were built from.
+type Cache struct {
+	Dir string
+}
+
+// CacheOff as the cache directory disables the build cache, like GOCACHE.
+const CacheOff = "off"
+
+// DefaultCacheDir returns `rbgo` in the user's cache directory.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "rbgo")
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key[:2], key)
+}
+
+// Get copies the output cached for key to dst, reporting whether it was found.
+func (c *Cache) Get(key, dst string) (bool, error) {
+	if len(key) < 2 {
+		return false, nil
+	}
+	src := c.path(key)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return false, nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Put stores the output at src for key.
+func (c *Cache) Put(key, src string) error {
+	if len(key) < 2 {
+		return nil
+	}
+	return copyFile(src, c.path(key))
+}
+
+// copyFile copies src through a temporary file in dst's directory, so readers
+// never see a partially written dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := ioutil.TempFile(filepath.Dir(dst), ".rbgo-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(out.Name(), fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
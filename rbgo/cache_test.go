@@ -0,0 +1,101 @@
+package rbgo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCache_Get(t *testing.T) {
+	dir := t.TempDir()
+	c := &Cache{Dir: filepath.Join(dir, "cache")}
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "pkg", "dst.a")
+	if found, err := c.Get("abcdef", dst); err != nil || found {
+		t.Fatalf("unexpected cache hit: %v", err)
+	}
+	if err := c.Put("abcdef", src); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := c.Get("abcdef", dst); err != nil || !found {
+		t.Fatalf("cache miss: %v", err)
+	}
+	data, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := string(data), "archive"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestTask_Build_Cache(t *testing.T) {
+	repo, pkgs := newExampleRepository()
+	m, err := LoadManifest(filepath.Join(t.TempDir(), ManifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Manifest = m
+	repo.Cache = &Cache{Dir: t.TempDir()}
+	task := newJob(pkgs[2], repo)
+	if err := task.Build(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(task.ObjectPath)
+	if err := os.Remove(task.ObjectPath); err != nil {
+		t.Fatal(err)
+	}
+	m.Delete(task.ObjectPath)
+	// restoring from the cache doesn't need the go command
+	t.Setenv("PATH", "")
+	if err := task.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if a, e := task.Stale(), false; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestTask_Build_Cache_Module(t *testing.T) {
+	ws := newTempModule(t, map[string]string{
+		"hello/hello.go": "package hello\n\nfunc Hello() string { return \"hello\" }\n",
+	})
+	ws.Package.Cache = &Cache{Dir: t.TempDir()}
+	factory := TaskFactory{Package: ws.Package}
+	task, err := factory.New(filepath.Join(ws.root, "hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Build(); err != nil {
+		t.Fatal(err)
+	}
+	key, err := task.InputHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another branch's go.mod keys another object
+	mod := filepath.Join(ws.root, ModFileName)
+	data, err := ioutil.ReadFile(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(mod, append(data, "\nrequire golang.org/x/sys v0.13.0\n"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other, err := task.InputHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == other {
+		t.Fatalf("same key for both go.mod: %s", key)
+	}
+	if found, err := ws.Package.Cache.Get(other, filepath.Join(t.TempDir(), "hello.a")); err != nil || found {
+		t.Errorf("unexpected cache hit: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package rbgo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var ConfigFileNames = []string{".rbgo.yml", ".rbgo.yaml"}
+
+// Config is the workspace configuration read from .rbgo.yml.
+type Config struct {
+	Exclude      []string          `yaml:"exclude"`
+	PackageRoots []string          `yaml:"package_roots"`
+	Targets      []TargetConfig    `yaml:"targets"`
+	Build        BuildConfig       `yaml:"build"`
+	Env          map[string]string `yaml:"env"`
+	Debounce     string            `yaml:"debounce"`
+	MaxWait      string            `yaml:"max_wait"`
+	Hooks        HooksConfig       `yaml:"hooks"`
+	Watcher      WatcherConfig     `yaml:"watcher"`
+	Platforms    []string          `yaml:"platforms"`
+	CacheDir     string            `yaml:"cache_dir"`
+	debounce     time.Duration
+	maxWait      time.Duration
+	pollInterval time.Duration
+	platforms    []Platform
+}
+
+type TargetConfig struct {
+	Package string   `yaml:"package"`
+	Output  string   `yaml:"output"`
+	Args    []string `yaml:"args"`
+	// Build adds to the workspace's build options for the package.
+	Build BuildConfig `yaml:"build"`
+}
+
+// BuildConfig are the build options, the ldflags a template of StampData.
+type BuildConfig struct {
+	Flags    []string          `yaml:"flags"`
+	Tags     []string          `yaml:"tags"`
+	Race     *bool             `yaml:"race"`
+	GCFlags  string            `yaml:"gcflags"`
+	LDFlags  string            `yaml:"ldflags"`
+	TrimPath *bool             `yaml:"trimpath"`
+	CGO      *bool             `yaml:"cgo"`
+	Env      map[string]string `yaml:"env"`
+}
+
+type HooksConfig struct {
+	PreBuild  []string `yaml:"pre_build"`
+	PostBuild []string `yaml:"post_build"`
+}
+
+type WatcherConfig struct {
+	Backend      string `yaml:"backend"`
+	PollInterval string `yaml:"poll_interval"`
+}
+
+// ConfigError is a validation error of the value at Key.
+type ConfigError struct {
+	File string
+	Key  string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.File, e.Key, e.Err)
+}
+
+// FindConfig returns the config file in the directory, if any.
+func FindConfig(dir string) (string, bool) {
+	for _, name := range ConfigFileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	// unknown keys are errors naming the key and its line
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := c.validate(); err != nil {
+		err.File = path
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) validate() *ConfigError {
+	for i, exclude := range c.Exclude {
+		if exclude == "" {
+			return &ConfigError{Key: fmt.Sprintf("exclude[%d]", i), Err: fmt.Errorf("empty directory name")}
+		}
+	}
+	for i, root := range c.PackageRoots {
+		if _, err := regexp.Compile(root); err != nil {
+			return &ConfigError{Key: fmt.Sprintf("package_roots[%d]", i), Err: err}
+		}
+	}
+	seen := map[string]bool{}
+	for i, target := range c.Targets {
+		if target.Package == "" {
+			return &ConfigError{Key: fmt.Sprintf("targets[%d].package", i), Err: fmt.Errorf("required")}
+		}
+		if seen[target.Package] {
+			return &ConfigError{Key: fmt.Sprintf("targets[%d].package", i), Err: fmt.Errorf("duplicate target `%s`", target.Package)}
+		}
+		seen[target.Package] = true
+		if err := target.Build.validate(fmt.Sprintf("targets[%d].build", i)); err != nil {
+			return err
+		}
+	}
+	if err := c.Build.validate("build"); err != nil {
+		return err
+	}
+	for key := range c.Env {
+		if key == "" {
+			return &ConfigError{Key: "env", Err: fmt.Errorf("empty variable name")}
+		}
+	}
+	var err *ConfigError
+	if c.debounce, err = parseDuration("debounce", c.Debounce); err != nil {
+		return err
+	}
+	if c.maxWait, err = parseDuration("max_wait", c.MaxWait); err != nil {
+		return err
+	}
+	switch c.Watcher.Backend {
+	case "", BackendNotify, BackendPoll:
+	default:
+		return &ConfigError{Key: "watcher.backend", Err: fmt.Errorf("unknown backend `%s`", c.Watcher.Backend)}
+	}
+	if c.pollInterval, err = parseDuration("watcher.poll_interval", c.Watcher.PollInterval); err != nil {
+		return err
+	}
+	c.platforms = []Platform{}
+	seen = map[string]bool{}
+	for i, s := range c.Platforms {
+		platform, err := ParsePlatform(s)
+		if err != nil {
+			return &ConfigError{Key: fmt.Sprintf("platforms[%d]", i), Err: err}
+		}
+		if seen[s] {
+			return &ConfigError{Key: fmt.Sprintf("platforms[%d]", i), Err: fmt.Errorf("duplicate platform `%s`", s)}
+		}
+		seen[s] = true
+		c.platforms = append(c.platforms, platform)
+	}
+	for i, hook := range c.Hooks.PreBuild {
+		if hook == "" {
+			return &ConfigError{Key: fmt.Sprintf("hooks.pre_build[%d]", i), Err: fmt.Errorf("empty command")}
+		}
+	}
+	for i, hook := range c.Hooks.PostBuild {
+		if hook == "" {
+			return &ConfigError{Key: fmt.Sprintf("hooks.post_build[%d]", i), Err: fmt.Errorf("empty command")}
+		}
+	}
+	return nil
+}
+
+func (b *BuildConfig) validate(key string) *ConfigError {
+	for i, tag := range b.Tags {
+		if tag == "" || strings.ContainsAny(tag, ", ") {
+			return &ConfigError{Key: fmt.Sprintf("%s.tags[%d]", key, i), Err: fmt.Errorf("invalid tag `%s`", tag)}
+		}
+	}
+	if _, err := parseLDFlags(b.LDFlags); err != nil {
+		return &ConfigError{Key: key + ".ldflags", Err: err}
+	}
+	for name := range b.Env {
+		if name == "" {
+			return &ConfigError{Key: key + ".env", Err: fmt.Errorf("empty variable name")}
+		}
+	}
+	return nil
+}
+
+// Options returns the build options configured, cgo as CGO_ENABLED.
+func (b *BuildConfig) Options() BuildOptions {
+	o := BuildOptions{
+		Flags:    b.Flags,
+		Tags:     b.Tags,
+		Race:     b.Race,
+		GCFlags:  b.GCFlags,
+		LDFlags:  b.LDFlags,
+		TrimPath: b.TrimPath,
+	}
+	if len(b.Env) > 0 || b.CGO != nil {
+		o.Env = map[string]string{}
+		for key, value := range b.Env {
+			o.Env[key] = value
+		}
+		if b.CGO != nil {
+			o.Env["CGO_ENABLED"] = "0"
+			if *b.CGO {
+				o.Env["CGO_ENABLED"] = "1"
+			}
+		}
+	}
+	return o
+}
+
+func (b *BuildConfig) isZero() bool {
+	return len(b.Flags) == 0 && len(b.Tags) == 0 && b.Race == nil && b.GCFlags == "" &&
+		b.LDFlags == "" && b.TrimPath == nil && b.CGO == nil && len(b.Env) == 0
+}
+
+func parseDuration(key, value string) (time.Duration, *ConfigError) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, &ConfigError{Key: key, Err: err}
+	}
+	if d < 0 {
+		return 0, &ConfigError{Key: key, Err: fmt.Errorf("negative duration")}
+	}
+	return d, nil
+}
+
+// DebounceDuration returns the configured quiet period, or zero.
+func (c *Config) DebounceDuration() time.Duration {
+	return c.debounce
+}
+
+// PollIntervalDuration returns the configured interval of the poll backend,
+// or zero.
+func (c *Config) PollIntervalDuration() time.Duration {
+	return c.pollInterval
+}
+
+// MaxWaitDuration returns the configured longest delay of a rebuild, or zero.
+func (c *Config) MaxWaitDuration() time.Duration {
+	return c.maxWait
+}
+
+// TargetPlatforms returns the configured platforms to build for, none for
+// the default platform only.
+func (c *Config) TargetPlatforms() []Platform {
+	return c.platforms
+}
+
+// TargetArgs returns the arguments the target's binary is run with.
+func (c *Config) TargetArgs() map[string][]string {
+	args := map[string][]string{}
+	for _, target := range c.Targets {
+		if len(target.Args) > 0 {
+			args[target.Package] = target.Args
+		}
+	}
+	return args
+}
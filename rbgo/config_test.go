@@ -0,0 +1,144 @@
+package rbgo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), ".rbgo.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+exclude: [node_modules]
+package_roots: ["gitlab.com/[a-zA-Z0-9_-]+/[a-zA-Z0-9_-]+"]
+targets:
+  - package: github.com/kai-zoa/example/cmd/greet
+    output: bin/greeter
+    args: ["-name", "rbgo"]
+    build:
+      tags: [pro]
+      ldflags: "-X main.commit={{.Commit}}"
+      cgo: true
+      race: false
+build:
+  flags: ["-v"]
+env:
+  CGO_ENABLED: "0"
+debounce: 300ms
+max_wait: 2s
+watcher:
+  backend: poll
+  poll_interval: 2s
+platforms: [linux/arm64, js/wasm]
+cache_dir: .cache/rbgo
+hooks:
+  pre_build: ["go generate ./..."]
+`)
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := c.DebounceDuration(), 300*time.Millisecond; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := c.MaxWaitDuration(), 2*time.Second; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := c.Watcher.Backend, BackendPoll; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := c.PollIntervalDuration(), 2*time.Second; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := c.TargetPlatforms(), []Platform{{"linux", "arm64"}, {"js", "wasm"}}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := c.TargetArgs(), map[string][]string{"github.com/kai-zoa/example/cmd/greet": {"-name", "rbgo"}}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := c.Hooks.PreBuild, []string{"go generate ./..."}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	w, err := NewWorkspace("../example/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.ApplyConfig(c)
+	if a, e := w.ExcludeDirs.Contains("web/node_modules"), true; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := w.PackageRoot.Find("gitlab.com/kai-zoa/rbgo/rbgo"), "gitlab.com/kai-zoa/rbgo"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := w.CacheDir, filepath.Join("../example/mod", ".cache", "rbgo"); a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	w.SetCacheDir(CacheOff)
+	if a, e := w.CacheDir, ""; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := w.Binaries["github.com/kai-zoa/example/cmd/greet"], "../example/mod/bin/greeter"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := w.Options, (BuildOptions{Flags: []string{"-v"}, Env: map[string]string{"CGO_ENABLED": "0"}}); !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	greet := &BuildOptions{Tags: []string{"pro"}, Race: boolPtr(false), LDFlags: "-X main.commit={{.Commit}}", Env: map[string]string{"CGO_ENABLED": "1"}}
+	if a, e := w.PackageOptions, map[string]*BuildOptions{"github.com/kai-zoa/example/cmd/greet": greet}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestLoadConfig_Error(t *testing.T) {
+	for _, c := range []struct {
+		content string
+		key     string
+	}{
+		{"exlude: [vendor]\n", "line 1: field exlude not found"},
+		{"debounce: [1s]\n", "line 1: cannot unmarshal"},
+		{"package_roots: [\"a\", \"(\"]\n", "package_roots[1]: "},
+		{"debounce: soon\n", "debounce: "},
+		{"max_wait: -1s\n", "max_wait: negative duration"},
+		{"watcher:\n  backend: kqueue\n", "watcher.backend: unknown backend `kqueue`"},
+		{"targets:\n  - output: bin/a\n", "targets[0].package: required"},
+		{"build:\n  ldflags: \"{{.Commit\"\n", "build.ldflags: "},
+		{"targets:\n  - package: a\n    build:\n      tags: [\"a,b\"]\n", "targets[0].build.tags[0]: invalid tag"},
+		{"targets:\n  - package: a\n    build:\n      env: {\"\": x}\n", "targets[0].build.env: empty variable name"},
+		{"platforms: [linux]\n", "platforms[0]: invalid platform `linux`"},
+		{"platforms: [js/wasm, js/wasm]\n", "platforms[1]: duplicate platform"},
+		{"hooks:\n  post_build: [\"\"]\n", "hooks.post_build[0]: "},
+	} {
+		_, err := LoadConfig(writeConfig(t, c.content))
+		if err == nil {
+			t.Errorf("no error: %q", c.content)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.key) {
+			t.Errorf("error doesn't point at `%s`: %v", c.key, err)
+		}
+	}
+}
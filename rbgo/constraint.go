@@ -0,0 +1,92 @@
+package rbgo
+
+import (
+	"go/build"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// DefaultBuildContext returns the go/build context of the target platform,
+// GOOS and GOARCH of the environment. Cgo is disabled when cross compiling
+// unless CGO_ENABLED is set, like the go command does.
+func DefaultBuildContext() *build.Context {
+	ctxt := build.Default
+	ctxt.GOOS, ctxt.GOARCH = buildOS, buildArch
+	if os.Getenv("CGO_ENABLED") == "" && (buildOS != runtime.GOOS || buildArch != runtime.GOARCH) {
+		ctxt.CgoEnabled = false
+	}
+	return &ctxt
+}
+
+// BuildContext returns the context the sources are matched against, with
+// the tags, the race detector and the GOOS, GOARCH and CGO_ENABLED variables
+// of the options applied.
+func (o *BuildOptions) BuildContext() *build.Context {
+	ctxt := DefaultBuildContext()
+	ctxt.BuildTags = o.tags()
+	if o.race() {
+		ctxt.BuildTags = append(ctxt.BuildTags, "race")
+	}
+	if goOS, found := o.Env["GOOS"]; found {
+		ctxt.GOOS = goOS
+	}
+	if goArch, found := o.Env["GOARCH"]; found {
+		ctxt.GOARCH = goArch
+	}
+	if cgo, found := o.Env["CGO_ENABLED"]; found {
+		ctxt.CgoEnabled = cgo == "1"
+	}
+	return ctxt
+}
+
+// tags returns the tags of the last -tags flag, comma or space separated,
+// followed by Tags.
+func (o *BuildOptions) tags() []string {
+	tags := []string{}
+	for i, flag := range o.Flags {
+		value := ""
+		flag = "-" + strings.TrimLeft(flag, "-")
+		if strings.HasPrefix(flag, "-tags=") {
+			value = strings.TrimPrefix(flag, "-tags=")
+		} else if flag == "-tags" && i+1 < len(o.Flags) {
+			value = o.Flags[i+1]
+		} else {
+			continue
+		}
+		tags = strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+	for _, tag := range o.Tags {
+		if !containsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// matchFiles splits the files of dir accepted by filter into the files
+// satisfying the build constraints and the excluded ones. The constraints
+// are the //go:build and // +build lines and the _GOOS and _GOARCH suffixes.
+func matchFiles(ctxt *build.Context, dir string, names []string, filter func(string) bool) ([]string, []string, error) {
+	if ctxt == nil {
+		ctxt = DefaultBuildContext()
+	}
+	matched, excluded := []string{}, []string{}
+	for _, name := range names {
+		if !filter(name) {
+			continue
+		}
+		match, err := ctxt.MatchFile(dir, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if match {
+			matched = append(matched, name)
+		} else {
+			excluded = append(excluded, name)
+		}
+	}
+	return matched, excluded, nil
+}
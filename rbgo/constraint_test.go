@@ -0,0 +1,83 @@
+package rbgo
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildOptions_BuildContext(t *testing.T) {
+	o := BuildOptions{
+		Flags: []string{"-v", "--tags", "foo,bar"},
+		Env:   map[string]string{"GOOS": "windows", "CGO_ENABLED": "0"},
+	}
+	ctxt := o.BuildContext()
+	if a, e := ctxt.BuildTags, []string{"foo", "bar"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := ctxt.GOOS, "windows"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if ctxt.CgoEnabled {
+		t.Error("cgo enabled")
+	}
+	o.Flags = []string{"-tags=baz"}
+	if a, e := o.BuildContext().BuildTags, []string{"baz"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestPackage_Scan_Constraints(t *testing.T) {
+	ws := newTempModule(t, map[string]string{
+		"os/os.go":               "package os\n",
+		"os/os_linux.go":         "package os\n\nimport \"example.com/temp/linux\"\n",
+		"os/os_windows_amd64.go": "package os\n\nimport \"example.com/temp/windows\"\n",
+		"os/tagged.go":           "//go:build foo\n\npackage os\n\nimport \"example.com/temp/foo\"\n",
+		"os/legacy.go":           "// +build !foo\n\npackage os\n\nimport \"example.com/temp/nofoo\"\n",
+		"os/doc.go":              "//go:build ignore\n\npackage main\n",
+		"os/os_windows_test.go":  "package os\n",
+		"os/os_test.go":          "package os\n",
+	})
+	dir := filepath.Join(ws.root, "os")
+	for _, c := range []struct {
+		options  BuildOptions
+		imports  []string
+		excluded []string
+	}{
+		{
+			BuildOptions{Env: map[string]string{"GOOS": "linux", "GOARCH": "amd64"}},
+			[]string{"example.com/temp/nofoo", "example.com/temp/linux"},
+			[]string{"doc.go", "os_windows_amd64.go", "tagged.go", "os_windows_test.go"},
+		},
+		{
+			BuildOptions{Flags: []string{"-tags", "foo"}, Env: map[string]string{"GOOS": "windows", "GOARCH": "amd64"}},
+			[]string{"example.com/temp/windows", "example.com/temp/foo"},
+			[]string{"doc.go", "legacy.go", "os_linux.go"},
+		},
+	} {
+		ws.Options = c.options
+		pkg := ws.NewPackage(dir)
+		if err := ws.Scan(pkg); err != nil {
+			t.Fatal(err)
+		}
+		if a, e := pkg.Imports, c.imports; !reflect.DeepEqual(a, e) {
+			err := "mismatch"
+			t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+		}
+		excluded := []string{}
+		for _, file := range pkg.ExcludedFiles {
+			excluded = append(excluded, filepath.Base(file))
+		}
+		if a, e := excluded, c.excluded; !reflect.DeepEqual(a, e) {
+			err := "mismatch"
+			t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+		}
+		if a, e := pkg.Name, "os"; a != e {
+			err := "mismatch"
+			t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package rbgo
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDebounce = 200 * time.Millisecond
+	DefaultMaxWait  = 5 * time.Second
+)
+
+// Debouncer coalesces events until no event arrived for Quiet, or MaxWait
+// passed since the first pending event, then signals Ready.
+type Debouncer struct {
+	Quiet   time.Duration
+	MaxWait time.Duration
+	pending map[string]*Event
+	order   []string
+	first   time.Time
+	timer   *time.Timer
+	ready   chan struct{}
+	m       sync.Mutex
+}
+
+func NewDebouncer(quiet, maxWait time.Duration) *Debouncer {
+	return &Debouncer{
+		Quiet:   quiet,
+		MaxWait: maxWait,
+		pending: map[string]*Event{},
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// Ready receives when the pending events are ready to Fetch.
+func (d *Debouncer) Ready() <-chan struct{} {
+	return d.ready
+}
+
+// Add adds the event, merging it with the pending event of its package.
+func (d *Debouncer) Add(e *Event) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	key := e.Pacakge.WatchPath
+	prev, found := d.pending[key]
+	if !found {
+		d.order = append(d.order, key)
+	}
+	d.pending[key] = mergeEvents(prev, e)
+
+	now := time.Now()
+	if len(d.pending) == 1 && !found {
+		d.first = now
+	}
+	wait := d.Quiet
+	if d.MaxWait > 0 {
+		if left := d.first.Add(d.MaxWait).Sub(now); left < wait {
+			wait = left
+		}
+	}
+	if d.timer == nil {
+		d.timer = time.AfterFunc(wait, d.fire)
+	} else {
+		d.timer.Reset(wait)
+	}
+}
+
+// mergeEvents returns the event standing for both events of a package. An
+// update includes the test update, and the later of an update and a delete
+// wins.
+func mergeEvents(prev, e *Event) *Event {
+	if prev != nil && prev.Name == EventUpdate && e.Name == EventTestUpdate {
+		return prev
+	}
+	return e
+}
+
+func (d *Debouncer) fire() {
+	select {
+	case d.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Fetch returns the pending events in the order their packages changed.
+func (d *Debouncer) Fetch() []*Event {
+	d.m.Lock()
+	defer d.m.Unlock()
+	events := make([]*Event, 0, len(d.order))
+	for _, key := range d.order {
+		events = append(events, d.pending[key])
+	}
+	d.pending = map[string]*Event{}
+	d.order = nil
+	return events
+}
+
+// Stop stops the timer, pending events are kept.
+func (d *Debouncer) Stop() {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
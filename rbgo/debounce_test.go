@@ -0,0 +1,63 @@
+package rbgo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	d := NewDebouncer(50*time.Millisecond, time.Minute)
+	defer d.Stop()
+	a := &Package{WatchPath: "src/a"}
+	b := &Package{WatchPath: "src/b"}
+	d.Add(&Event{Name: EventUpdate, Pacakge: a})
+	d.Add(&Event{Name: EventTestUpdate, Pacakge: b})
+	d.Add(&Event{Name: EventTestUpdate, Pacakge: a})
+	d.Add(&Event{Name: EventUpdate, Pacakge: b})
+	select {
+	case <-d.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("not ready")
+	}
+	events := d.Fetch()
+	if a, e := len(events), 2; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	for i, e := range []*Event{{Name: EventUpdate, Pacakge: a}, {Name: EventUpdate, Pacakge: b}} {
+		if events[i].Name != e.Name || events[i].Pacakge != e.Pacakge {
+			err := "mismatch"
+			t.Errorf("%s\nactual: %v\nexpect: %v", err, events[i], e)
+		}
+	}
+	if a, e := len(d.Fetch()), 0; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestDebouncer_MaxWait(t *testing.T) {
+	d := NewDebouncer(time.Minute, 100*time.Millisecond)
+	defer d.Stop()
+	pkg := &Package{WatchPath: "src/a"}
+	start := time.Now()
+	d.Add(&Event{Name: EventUpdate, Pacakge: pkg})
+	d.Add(&Event{Name: EventDelete, Pacakge: pkg})
+	select {
+	case <-d.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("not ready")
+	}
+	if elapse := time.Since(start); elapse > 5*time.Second {
+		t.Errorf("max wait exceeded: %v", elapse)
+	}
+	events := d.Fetch()
+	if a, e := len(events), 1; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := events[0].Name, EventDelete; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
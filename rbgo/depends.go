@@ -0,0 +1,147 @@
+package rbgo
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// dependLinks records how a package was linked into the import graph, so it
+// can be unlinked after it's rescanned.
+type dependLinks struct {
+	name    string
+	imports []*Package
+	// outside are the imports not in the repository, found or missing
+	outside []string
+	// project is the vendored project of the package, if any
+	project string
+}
+
+// UpdateDepends relinks every package from scratch. Put and Delete keep the
+// graph up to date by themselves, this recomputes it after the packages were
+// modified in place.
+func (r *PackageRepository) UpdateDepends() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.updateDepends()
+}
+
+func (r *PackageRepository) updateDepends() {
+	r.extPrj = map[string]map[*Package]bool{}
+	r.links = map[*Package]*dependLinks{}
+	r.outside = map[string]map[*Package]bool{}
+	r.goRoot = map[string]bool{}
+	all := r.all()
+	for _, pkg := range all {
+		pkg.Referrers = []*Package{}
+	}
+	for _, pkg := range all {
+		r.link(pkg)
+	}
+}
+
+// link resolves the imports of pkg, adding it to the referrers of the
+// imported packages, or to the importers of the paths outside of the
+// repository to be relinked when a package of the path is put.
+func (r *PackageRepository) link(pkg *Package) {
+	l := &dependLinks{name: pkg.FullName}
+	pkg.MissingImports = make([]string, 0, len(pkg.MissingImports))
+	for _, imp := range pkg.Imports {
+		if imp == "C" || imp == "appengine/cloudsql" {
+			continue
+		}
+		if ref := r.findByImportName(imp); ref != nil {
+			addReferrer(ref, pkg)
+			l.imports = append(l.imports, ref)
+			continue
+		}
+		l.outside = append(l.outside, imp)
+		importers, found := r.outside[imp]
+		if !found {
+			importers = map[*Package]bool{}
+			r.outside[imp] = importers
+		}
+		importers[pkg] = true
+		if !r.external(pkg, imp) {
+			pkg.MissingImports = append(pkg.MissingImports, imp)
+		}
+	}
+	if pkg.InVendor {
+		l.project = pkg.ProjectName
+		prj, found := r.extPrj[l.project]
+		if !found {
+			prj = map[*Package]bool{}
+			r.extPrj[l.project] = prj
+		}
+		prj[pkg] = true
+	}
+	r.links[pkg] = l
+}
+
+// unlink removes the edges link added for pkg.
+func (r *PackageRepository) unlink(pkg *Package) {
+	l, found := r.links[pkg]
+	if !found {
+		return
+	}
+	for _, ref := range l.imports {
+		removeReferrer(ref, pkg)
+	}
+	for _, imp := range l.outside {
+		delete(r.outside[imp], pkg)
+		if len(r.outside[imp]) == 0 {
+			delete(r.outside, imp)
+		}
+	}
+	if l.project != "" {
+		delete(r.extPrj[l.project], pkg)
+		if len(r.extPrj[l.project]) == 0 {
+			delete(r.extPrj, l.project)
+		}
+	}
+	delete(r.links, pkg)
+}
+
+func (r *PackageRepository) relink(pkg *Package) {
+	r.unlink(pkg)
+	r.link(pkg)
+}
+
+// external reports whether imp is found outside of the repository, in the
+// module's dependencies or in GOROOT.
+func (r *PackageRepository) external(pkg *Package, imp string) bool {
+	if pkg.Module != nil {
+		if _, found := pkg.Module.ImportDir(imp); found {
+			return true
+		}
+	}
+	// GOROOT doesn't change while watching
+	found, cached := r.goRoot[imp]
+	if !cached {
+		list := []string{runtime.GOROOT(), "src"}
+		list = append(list, strings.Split(imp, "/")...)
+		_, err := os.Stat(filepath.Join(list...))
+		found = err == nil
+		r.goRoot[imp] = found
+	}
+	return found
+}
+
+func addReferrer(pkg, ref *Package) {
+	for _, p := range pkg.Referrers {
+		if p == ref {
+			return
+		}
+	}
+	pkg.Referrers = append(pkg.Referrers, ref)
+}
+
+func removeReferrer(pkg, ref *Package) {
+	for i, p := range pkg.Referrers {
+		if p == ref {
+			pkg.Referrers = append(pkg.Referrers[:i:i], pkg.Referrers[i+1:]...)
+			return
+		}
+	}
+}
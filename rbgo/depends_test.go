@@ -0,0 +1,129 @@
+package rbgo
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPackageRepository_Put_Relink(t *testing.T) {
+	repo := new(PackageRepository).Init()
+	a := newMemoryPackage(repo, "a", "b", "fmt")
+	if a, e := a.MissingImports, []string{"b"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	b := newMemoryPackage(repo, "b")
+	if a, e := a.MissingImports, []string{}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	// rescanned packages don't pile up
+	for i := 0; i < 3; i++ {
+		repo.Put(a)
+		repo.Put(b)
+		repo.UpdateDepends()
+	}
+	if a, e := b.Referrers, []*Package{a}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := repo.FindByDir("/nonexistent/src"), []*Package{a, b}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	a.Imports = []string{"fmt"}
+	repo.Put(a)
+	if a, e := b.Referrers, []*Package{}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestPackageRepository_Delete_Relink(t *testing.T) {
+	repo := new(PackageRepository).Init()
+	a := newMemoryPackage(repo, "a", "b")
+	b := newMemoryPackage(repo, "b")
+	repo.Delete(b)
+	if a, e := a.MissingImports, []string{"b"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	repo.Put(b)
+	// renamed, the importers of the old name miss it
+	b.FullName = "c"
+	repo.Put(b)
+	if a, e := a.MissingImports, []string{"b"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := b.Referrers, []*Package{}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if repo.FindByImportName("b") != nil {
+		t.Error("old name still found")
+	}
+}
+
+// newSyntheticRepository returns n packages, every package importing fmt and
+// up to two packages before it.
+func newSyntheticRepository(n int) (*PackageRepository, []*Package) {
+	repo := new(PackageRepository).Init()
+	pkgs := make([]*Package, n)
+	for i := range pkgs {
+		imports := []string{"fmt"}
+		for _, j := range []int{i / 2, i / 3} {
+			if j < i {
+				imports = append(imports, fmt.Sprintf("p%d", j))
+			}
+		}
+		pkgs[i] = newMemoryPackage(repo, fmt.Sprintf("p%d", i), imports...)
+	}
+	return repo, pkgs
+}
+
+func TestPackageRepository_Put_Synthetic(t *testing.T) {
+	repo, pkgs := newSyntheticRepository(500)
+	// change some imports and compare with the graph built from scratch
+	for i := 10; i < len(pkgs); i += 7 {
+		pkgs[i].Imports = []string{fmt.Sprintf("p%d", i-1), "missing"}
+		repo.Put(pkgs[i])
+	}
+	repo.Delete(pkgs[3])
+	incremental := map[*Package]map[*Package]bool{}
+	for _, pkg := range repo.All() {
+		incremental[pkg] = referrerSet(pkg)
+	}
+	repo.UpdateDepends()
+	for _, pkg := range repo.All() {
+		if a, e := incremental[pkg], referrerSet(pkg); !reflect.DeepEqual(a, e) {
+			err := "mismatch"
+			t.Errorf("%s: %s\nactual: %v\nexpect: %v", pkg.FullName, err, a, e)
+		}
+	}
+}
+
+func referrerSet(pkg *Package) map[*Package]bool {
+	set := map[*Package]bool{}
+	for _, ref := range pkg.Referrers {
+		set[ref] = true
+	}
+	return set
+}
+
+func BenchmarkPackageRepository_Put(b *testing.B) {
+	repo, pkgs := newSyntheticRepository(5000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		repo.Put(pkgs[i%len(pkgs)])
+	}
+}
+
+func BenchmarkPackageRepository_UpdateDepends(b *testing.B) {
+	repo, _ := newSyntheticRepository(5000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		repo.UpdateDepends()
+	}
+}
@@ -0,0 +1,83 @@
+package rbgo
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a message of the compiler about a position in a source file.
+type Diagnostic struct {
+	Package string `json:"package"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+// BuildError is a failed `go build`. Output is what it wrote to stderr and
+// Diagnostics are parsed from it.
+type BuildError struct {
+	Package     string
+	Output      string
+	Diagnostics []Diagnostic
+	Err         error
+}
+
+func (e *BuildError) Error() string {
+	if e.Output == "" {
+		return e.Err.Error()
+	}
+	return e.Output
+}
+
+func newBuildError(t *Task, dir string, output []byte, err error) *BuildError {
+	return &BuildError{
+		Package:     t.PackageName,
+		Output:      string(output),
+		Diagnostics: ParseBuildOutput(dir, t.PackageName, string(output)),
+		Err:         err,
+	}
+}
+
+var diagnosticPattern = regexp.MustCompile(`^(\S.*?\.go):(\d+)(?::(\d+))?: (.*)$`)
+
+// ParseBuildOutput parses the `go build` output run in dir into diagnostics.
+// Files are made absolute and a message continued on indented lines is
+// joined by newlines. Diagnostics before a `# package` header belong to pkg.
+func ParseBuildOutput(dir, pkg, output string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "# ") {
+			pkg = strings.TrimPrefix(line, "# ")
+			continue
+		}
+		if strings.HasPrefix(line, "\t") && len(diagnostics) > 0 {
+			d := &diagnostics[len(diagnostics)-1]
+			d.Message += "\n" + strings.TrimPrefix(line, "\t")
+			continue
+		}
+		m := diagnosticPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		file := m[1]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		d := Diagnostic{Package: pkg, File: file, Message: m[4]}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
@@ -0,0 +1,43 @@
+package rbgo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBuildOutput(t *testing.T) {
+	output := "# github.com/kai-zoa/example/greeting\n" +
+		"greeting/greeting.go:9:2: undefined: hello.Hi\n" +
+		"greeting/greeting.go:4:2: \"strings\" imported and not used\n" +
+		"vendor/github.com/kai-zoa/geeyoko/geeyoko.go:3:8: cannot find package \"github.com/kai-zoa/yokohama\" in any of:\n" +
+		"\t/usr/local/go/src/github.com/kai-zoa/yokohama (from $GOROOT)\n" +
+		"/abs/main.go:12: missing return\n" +
+		"note: module requires Go 1.99\n"
+	expect := []Diagnostic{
+		{Package: "github.com/kai-zoa/example/greeting", File: "/work/greeting/greeting.go", Line: 9, Column: 2, Message: "undefined: hello.Hi"},
+		{Package: "github.com/kai-zoa/example/greeting", File: "/work/greeting/greeting.go", Line: 4, Column: 2, Message: "\"strings\" imported and not used"},
+		{Package: "github.com/kai-zoa/example/greeting", File: "/work/vendor/github.com/kai-zoa/geeyoko/geeyoko.go", Line: 3, Column: 8,
+			Message: "cannot find package \"github.com/kai-zoa/yokohama\" in any of:\n/usr/local/go/src/github.com/kai-zoa/yokohama (from $GOROOT)"},
+		{Package: "github.com/kai-zoa/example/greeting", File: "/abs/main.go", Line: 12, Message: "missing return"},
+	}
+	if a, e := ParseBuildOutput("/work", "greeting", output), expect; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestParseBuildOutput_NoHeader(t *testing.T) {
+	diagnostics := ParseBuildOutput("/work", "hoge/piyo", "src/hoge/piyo/piyo.go:1:1: expected 'package', found 'EOF'\n")
+	if a, e := len(diagnostics), 1; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := diagnostics[0].Package, "hoge/piyo"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := diagnostics[0].String(), "/work/src/hoge/piyo/piyo.go:1:1: expected 'package', found 'EOF'"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
@@ -0,0 +1,403 @@
+package rbgo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	BackendNotify = "fsnotify"
+	BackendPoll   = "poll"
+
+	DefaultPollInterval = time.Second
+)
+
+// FileEvent tells that the file or directory at Path was created, written,
+// renamed or removed.
+type FileEvent struct {
+	Path string
+}
+
+// FileWatcher watches the entries of directories.
+type FileWatcher interface {
+	Add(dir string) error
+	Remove(dir string) error
+	Events() <-chan FileEvent
+	Errors() <-chan error
+	// Close stops watching and closes the channels.
+	Close() error
+}
+
+// NewFileWatcher returns a watcher of the backend, fsnotify if empty. The
+// fsnotify backend polls the directories beyond the OS's limit of watches
+// every interval.
+func NewFileWatcher(backend string, interval time.Duration) (FileWatcher, error) {
+	switch backend {
+	case "", BackendNotify:
+		w, err := NewNotifyWatcher()
+		if err != nil {
+			return nil, err
+		}
+		return NewFallbackWatcher(w, interval), nil
+	case BackendPoll:
+		return NewPollWatcher(interval), nil
+	}
+	return nil, fmt.Errorf("unknown watcher backend `%s`", backend)
+}
+
+// NotifyWatcher is notified of the changes by the OS, e.g. inotify on Linux.
+type NotifyWatcher struct {
+	w      *fsnotify.Watcher
+	events chan FileEvent
+}
+
+func NewNotifyWatcher() (*NotifyWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	n := &NotifyWatcher{w: w, events: make(chan FileEvent)}
+	go func() {
+		defer close(n.events)
+		for e := range w.Events {
+			// permissions don't change what is built
+			if e.Op == fsnotify.Chmod {
+				continue
+			}
+			n.events <- FileEvent{Path: e.Name}
+		}
+	}()
+	return n, nil
+}
+
+func (n *NotifyWatcher) Add(dir string) error {
+	return n.w.Add(dir)
+}
+
+func (n *NotifyWatcher) Remove(dir string) error {
+	return n.w.Remove(dir)
+}
+
+func (n *NotifyWatcher) Events() <-chan FileEvent {
+	return n.events
+}
+
+func (n *NotifyWatcher) Errors() <-chan error {
+	return n.w.Errors
+}
+
+func (n *NotifyWatcher) Close() error {
+	err := n.w.Close()
+	// let the forwarder see w.Events closed
+	for range n.events {
+	}
+	return err
+}
+
+// PollWatcher compares the entries of the directories every Interval, for
+// filesystems not notifying changes like network filesystems and some
+// container mounts.
+type PollWatcher struct {
+	Interval time.Duration
+	dirs     map[string]map[string]fileState
+	events   chan FileEvent
+	errors   chan error
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+	m        sync.Mutex
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+}
+
+func NewPollWatcher(interval time.Duration) *PollWatcher {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	p := &PollWatcher{
+		Interval: interval,
+		dirs:     map[string]map[string]fileState{},
+		events:   make(chan FileEvent),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *PollWatcher) Add(dir string) error {
+	entries, err := readEntries(dir)
+	if err != nil {
+		return err
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.dirs[dir] = entries
+	return nil
+}
+
+func (p *PollWatcher) Remove(dir string) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if _, found := p.dirs[dir]; !found {
+		return fmt.Errorf("not watched: `%s`", dir)
+	}
+	delete(p.dirs, dir)
+	return nil
+}
+
+func (p *PollWatcher) Events() <-chan FileEvent {
+	return p.events
+}
+
+func (p *PollWatcher) Errors() <-chan error {
+	return p.errors
+}
+
+func (p *PollWatcher) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+		<-p.stopped
+		close(p.events)
+		close(p.errors)
+	})
+	return nil
+}
+
+func (p *PollWatcher) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		for _, path := range p.poll() {
+			select {
+			case p.events <- FileEvent{Path: path}:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+// poll returns the paths changed since the previous poll. A directory
+// which can't be read any more is reported itself and unwatched.
+func (p *PollWatcher) poll() []string {
+	p.m.Lock()
+	defer p.m.Unlock()
+	changes := []string{}
+	for dir, prev := range p.dirs {
+		entries, err := readEntries(dir)
+		if err != nil {
+			delete(p.dirs, dir)
+			changes = append(changes, dir)
+			continue
+		}
+		for name, state := range entries {
+			if old, found := prev[name]; !found || old != state {
+				changes = append(changes, filepath.Join(dir, name))
+			}
+		}
+		for name := range prev {
+			if _, found := entries[name]; !found {
+				changes = append(changes, filepath.Join(dir, name))
+			}
+		}
+		p.dirs[dir] = entries
+	}
+	return changes
+}
+
+func readEntries(dir string) (map[string]fileState, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]fileState, len(infos))
+	for _, fi := range infos {
+		state := fileState{modTime: fi.ModTime(), size: fi.Size(), mode: fi.Mode()}
+		if fi.IsDir() {
+			// entries of subdirectories are watched by themselves
+			state.modTime, state.size = time.Time{}, 0
+		}
+		entries[fi.Name()] = state
+	}
+	return entries, nil
+}
+
+// IsWatchLimit reports whether the error is due to the OS's limit of
+// watches, fs.inotify.max_user_watches on Linux or the open files with
+// kqueue.
+func IsWatchLimit(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EMFILE)
+}
+
+// WatchLimitError tells that only Watched of the Requested directories are
+// watched, the rest are polled.
+type WatchLimitError struct {
+	Requested int
+	Watched   int
+	Interval  time.Duration
+	Err       error
+}
+
+func (e *WatchLimitError) Error() string {
+	return fmt.Sprintf("watch limit reached (%v): watching %d of %d directories, polling the other %d every %v; "+
+		"raise fs.inotify.max_user_watches or exclude directories to watch them all",
+		e.Err, e.Watched, e.Requested, e.Requested-e.Watched, e.Interval)
+}
+
+func (e *WatchLimitError) Unwrap() error {
+	return e.Err
+}
+
+// FallbackWatcher adds the directories to a PollWatcher once the primary
+// watcher hit the watch limit.
+type FallbackWatcher struct {
+	primary FileWatcher
+	poll    *PollWatcher
+	watched map[string]bool
+	polled  map[string]bool
+	limit   error
+	events  chan FileEvent
+	errors  chan error
+	done    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+	m       sync.Mutex
+}
+
+func NewFallbackWatcher(primary FileWatcher, interval time.Duration) *FallbackWatcher {
+	f := &FallbackWatcher{
+		primary: primary,
+		poll:    NewPollWatcher(interval),
+		watched: map[string]bool{},
+		polled:  map[string]bool{},
+		events:  make(chan FileEvent),
+		errors:  make(chan error),
+		done:    make(chan struct{}),
+	}
+	for _, src := range []FileWatcher{f.primary, f.poll} {
+		f.wg.Add(2)
+		go func(src FileWatcher) {
+			defer f.wg.Done()
+			for e := range src.Events() {
+				select {
+				case f.events <- e:
+				case <-f.done:
+				}
+			}
+		}(src)
+		go func(src FileWatcher) {
+			defer f.wg.Done()
+			for err := range src.Errors() {
+				select {
+				case f.errors <- err:
+				case <-f.done:
+				}
+			}
+		}(src)
+	}
+	return f
+}
+
+func (f *FallbackWatcher) Add(dir string) error {
+	err := f.primary.Add(dir)
+	if err == nil {
+		f.m.Lock()
+		f.watched[dir] = true
+		f.m.Unlock()
+	}
+	if !IsWatchLimit(err) {
+		return err
+	}
+	if err := f.poll.Add(dir); err != nil {
+		return err
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.polled[dir] = true
+	if f.limit == nil {
+		f.limit = err
+	}
+	return nil
+}
+
+func (f *FallbackWatcher) Remove(dir string) error {
+	f.m.Lock()
+	polled := f.polled[dir]
+	delete(f.watched, dir)
+	delete(f.polled, dir)
+	f.m.Unlock()
+	if polled {
+		return f.poll.Remove(dir)
+	}
+	return f.primary.Remove(dir)
+}
+
+// Polled returns the number of polled directories and the error of the
+// primary watcher which made them polled.
+func (f *FallbackWatcher) Polled() (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return len(f.polled), f.limit
+}
+
+// PollInterval is the interval the directories beyond the limit are polled.
+func (f *FallbackWatcher) PollInterval() time.Duration {
+	return f.poll.Interval
+}
+
+// LimitError returns the WatchLimitError of all the directories added so far,
+// nil if none is polled.
+func (f *FallbackWatcher) LimitError() *WatchLimitError {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if len(f.polled) == 0 {
+		return nil
+	}
+	return &WatchLimitError{
+		Requested: len(f.watched) + len(f.polled),
+		Watched:   len(f.watched),
+		Interval:  f.poll.Interval,
+		Err:       f.limit,
+	}
+}
+
+func (f *FallbackWatcher) Events() <-chan FileEvent {
+	return f.events
+}
+
+func (f *FallbackWatcher) Errors() <-chan error {
+	return f.errors
+}
+
+func (f *FallbackWatcher) Close() error {
+	var err error
+	f.once.Do(func() {
+		close(f.done)
+		err = f.primary.Close()
+		f.poll.Close()
+		f.wg.Wait()
+		close(f.events)
+		close(f.errors)
+	})
+	return err
+}
@@ -0,0 +1,146 @@
+package rbgo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// expectFileEvent waits for an event of the path, skipping the others.
+func expectFileEvent(t *testing.T, w FileWatcher, path string) {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case e := <-w.Events():
+			if e.Path == path {
+				return
+			}
+		case err := <-w.Errors():
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("no event: `%s`", path)
+		}
+	}
+}
+
+func testFileWatcher(t *testing.T, w FileWatcher) {
+	defer w.Close()
+	dir := t.TempDir()
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "a.go")
+	if err := ioutil.WriteFile(file, []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectFileEvent(t, w, file)
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	expectFileEvent(t, w, file)
+}
+
+func TestNotifyWatcher(t *testing.T) {
+	w, err := NewNotifyWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFileWatcher(t, w)
+}
+
+func TestPollWatcher(t *testing.T) {
+	testFileWatcher(t, NewPollWatcher(10*time.Millisecond))
+}
+
+func TestPollWatcher_RemoveDir(t *testing.T) {
+	w := NewPollWatcher(10 * time.Millisecond)
+	defer w.Close()
+	dir := filepath.Join(t.TempDir(), "a")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	expectFileEvent(t, w, dir)
+	if err := w.Remove(dir); err == nil {
+		t.Errorf("still watched: `%s`", dir)
+	}
+}
+
+func TestNewFileWatcher_Unknown(t *testing.T) {
+	if _, err := NewFileWatcher("kqueue", 0); err == nil {
+		t.Error("no error")
+	}
+}
+
+// limitedWatcher refuses directories beyond its limit like inotify.
+type limitedWatcher struct {
+	*PollWatcher
+	limit int
+	dirs  int
+}
+
+func (l *limitedWatcher) Add(dir string) error {
+	if l.dirs == l.limit {
+		return syscall.ENOSPC
+	}
+	l.dirs++
+	return l.PollWatcher.Add(dir)
+}
+
+func TestFallbackWatcher(t *testing.T) {
+	primary := &limitedWatcher{PollWatcher: NewPollWatcher(time.Hour), limit: 1}
+	w := NewFallbackWatcher(primary, 10*time.Millisecond)
+	defer w.Close()
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for _, dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	polled, err := w.Polled()
+	if a, e := polled, 1; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if !IsWatchLimit(err) {
+		t.Errorf("not a watch limit: %v", err)
+	}
+	// the primary never polls, the second directory is polled instead
+	file := filepath.Join(dirs[1], "a.go")
+	if err := ioutil.WriteFile(file, []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectFileEvent(t, w, file)
+	limit := w.LimitError()
+	if a, e := limit, (&WatchLimitError{Requested: 2, Watched: 1, Interval: 10 * time.Millisecond, Err: err}); !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if !IsWatchLimit(limit) {
+		t.Errorf("not a watch limit: %v", limit)
+	}
+	// the directories added later are counted too
+	if err := w.Add(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if a, e := w.LimitError(), (&WatchLimitError{Requested: 3, Watched: 1, Interval: 10 * time.Millisecond, Err: err}); !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if err := w.Remove(dirs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if a, e := w.LimitError(), (&WatchLimitError{Requested: 2, Watched: 1, Interval: 10 * time.Millisecond, Err: err}); !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
@@ -0,0 +1,81 @@
+package rbgo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const ManifestFileName = "rbgo-manifest.json"
+
+// Manifest records the input hash every object was last built from.
+type Manifest struct {
+	path    string
+	objects map[string]string
+	m       sync.Mutex
+}
+
+// LoadManifest reads the manifest at path, a missing file being an empty
+// manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	m := &Manifest{
+		path:    path,
+		objects: map[string]string{},
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.objects); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Manifest) Get(object string) string {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return m.objects[manifestKey(object)]
+}
+
+func (m *Manifest) Set(object, hash string) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.objects[manifestKey(object)] = hash
+}
+
+func (m *Manifest) Delete(object string) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	delete(m.objects, manifestKey(object))
+}
+
+func (m *Manifest) Save() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	data, err := json.MarshalIndent(m.objects, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	// replace the file at once so a crash never leaves it truncated
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+func manifestKey(object string) string {
+	abs, err := filepath.Abs(object)
+	if err != nil {
+		return object
+	}
+	return abs
+}
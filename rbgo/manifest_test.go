@@ -0,0 +1,74 @@
+package rbgo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManifest_Save(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pkg", ManifestFileName)
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Set("pkg/hoge/piyo.a", "1234")
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	m, err = LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := m.Get("pkg/hoge/piyo.a"), "1234"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := m.Get("pkg/hoge/fuga.a"), ""; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestTask_Stale_Manifest(t *testing.T) {
+	repo, pkgs := newExampleRepository()
+	m, err := LoadManifest(filepath.Join(t.TempDir(), ManifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Manifest = m
+	task := newJob(pkgs[2], repo)
+	if a, e := task.Stale(), true; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if err := task.Build(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(task.ObjectPath)
+	if a, e := task.Stale(), false; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	// touching the sources doesn't change the inputs
+	source := pkgs[2].Files[0]
+	fi, err := os.Stat(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(source, future, future); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chtimes(source, fi.ModTime(), fi.ModTime())
+	if a, e := task.Stale(), false; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	m.Set(task.ObjectPath, "outdated")
+	if a, e := task.Stale(), true; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
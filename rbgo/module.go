@@ -0,0 +1,378 @@
+package rbgo
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const (
+	ModFileName  = "go.mod"
+	WorkFileName = "go.work"
+)
+
+// Module
+type Module struct {
+	Path    string
+	Dir     string
+	Go      string
+	Require []ModuleVersion
+	Replace []ModuleReplace
+}
+
+type ModuleVersion struct {
+	Path    string
+	Version string
+}
+
+// ModuleReplace is a replace directive. Dir is set when the replacement is a
+// local directory, resolved against the directory of the go.mod or go.work.
+type ModuleReplace struct {
+	Old ModuleVersion
+	New ModuleVersion
+	Dir string
+}
+
+func ReadModFile(dir string) (*Module, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ModFileName))
+	if err != nil {
+		return nil, err
+	}
+	return ParseModFile(dir, data)
+}
+
+// ReadModules reads the modules of the go.work in dir, or of the go.mod if
+// there's no go.work. It returns none if neither exists.
+func ReadModules(dir string) ([]*Module, error) {
+	if _, err := os.Stat(filepath.Join(dir, WorkFileName)); err == nil {
+		return ReadWorkFile(dir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ModFileName)); err != nil {
+		return nil, nil
+	}
+	m, err := ReadModFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	return []*Module{m}, nil
+}
+
+func ParseModFile(dir string, data []byte) (*Module, error) {
+	m := &Module{
+		Dir:     dir,
+		Require: []ModuleVersion{},
+		Replace: []ModuleReplace{},
+	}
+	err := parseDirectives(data, func(verb string, args []string) error {
+		switch verb {
+		case "module":
+			if len(args) != 1 {
+				return fmt.Errorf("usage: module module/path")
+			}
+			m.Path = args[0]
+		case "go":
+			if len(args) != 1 {
+				return fmt.Errorf("usage: go 1.23")
+			}
+			m.Go = args[0]
+		case "require":
+			if len(args) != 2 {
+				return fmt.Errorf("usage: require module/path v1.2.3")
+			}
+			m.Require = append(m.Require, ModuleVersion{Path: args[0], Version: args[1]})
+		case "replace":
+			r, err := parseReplace(dir, args)
+			if err != nil {
+				return err
+			}
+			m.Replace = append(m.Replace, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, ModFileName), err)
+	}
+	if m.Path == "" {
+		return nil, fmt.Errorf("%s: no module declaration", filepath.Join(dir, ModFileName))
+	}
+	return m, nil
+}
+
+// ReadWorkFile reads go.work in dir and returns the modules it uses, with the
+// workspace level replace directives taking precedence over their own.
+func ReadWorkFile(dir string) ([]*Module, error) {
+	path := filepath.Join(dir, WorkFileName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	uses := []string{}
+	replaces := []ModuleReplace{}
+	err = parseDirectives(data, func(verb string, args []string) error {
+		switch verb {
+		case "use":
+			if len(args) != 1 {
+				return fmt.Errorf("usage: use local/dir")
+			}
+			uses = append(uses, filepath.Join(dir, filepath.FromSlash(args[0])))
+		case "replace":
+			r, err := parseReplace(dir, args)
+			if err != nil {
+				return err
+			}
+			replaces = append(replaces, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	modules := make([]*Module, 0, len(uses))
+	for _, u := range uses {
+		m, err := ReadModFile(u)
+		if err != nil {
+			return nil, err
+		}
+		m.Replace = append(append([]ModuleReplace{}, replaces...), m.Replace...)
+		modules = append(modules, m)
+	}
+	return modules, nil
+}
+
+func parseReplace(dir string, args []string) (ModuleReplace, error) {
+	r := ModuleReplace{}
+	i := 0
+	for ; i < len(args); i++ {
+		if args[i] == "=>" {
+			break
+		}
+	}
+	from, to := args[:i], []string{}
+	if i < len(args) {
+		to = args[i+1:]
+	}
+	if len(from) < 1 || len(from) > 2 || len(to) < 1 || len(to) > 2 {
+		return r, fmt.Errorf("usage: replace module/path [v1.2.3] => other/module v1.4\n\t or replace module/path [v1.2.3] => ../local/directory")
+	}
+	r.Old.Path = from[0]
+	if len(from) == 2 {
+		r.Old.Version = from[1]
+	}
+	r.New.Path = to[0]
+	if len(to) == 2 {
+		r.New.Version = to[1]
+	} else if isLocalPath(to[0]) {
+		r.Dir = to[0]
+		if !filepath.IsAbs(r.Dir) {
+			r.Dir = filepath.Join(dir, filepath.FromSlash(r.Dir))
+		}
+	} else {
+		return r, fmt.Errorf("replacement module without version must be directory path (rooted or starting with ./ or ../)")
+	}
+	return r, nil
+}
+
+func isLocalPath(path string) bool {
+	return filepath.IsAbs(path) ||
+		path == "." || path == ".." ||
+		strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../")
+}
+
+// parseDirectives calls f for every directive in a go.mod or go.work file,
+// expanding `verb ( ... )` blocks into one call per line.
+func parseDirectives(data []byte, f func(verb string, args []string) error) error {
+	block := ""
+	for n, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "//"); i != -1 {
+			line = line[:i]
+		}
+		fields, err := splitFields(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n+1, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if block != "" {
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+			if err := f(block, fields); err != nil {
+				return fmt.Errorf("line %d: %v", n+1, err)
+			}
+			continue
+		}
+		if len(fields) == 2 && fields[1] == "(" {
+			block = fields[0]
+			continue
+		}
+		if err := f(fields[0], fields[1:]); err != nil {
+			return fmt.Errorf("line %d: %v", n+1, err)
+		}
+	}
+	if block != "" {
+		return fmt.Errorf("unterminated %s block", block)
+	}
+	return nil
+}
+
+func splitFields(line string) ([]string, error) {
+	fields := []string{}
+	for {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" {
+			return fields, nil
+		}
+		switch line[0] {
+		case '"', '`':
+			end := strings.IndexByte(line[1:], line[0])
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated quoted string")
+			}
+			fields = append(fields, line[1:end+1])
+			line = line[end+2:]
+		default:
+			end := strings.IndexFunc(line, unicode.IsSpace)
+			if end == -1 {
+				end = len(line)
+			}
+			fields = append(fields, line[:end])
+			line = line[end:]
+		}
+	}
+}
+
+// writeInputs writes what the builds of the module's packages depend on to w:
+// its go.mod and go.sum and the go.mod of the directories replacing modules.
+func (m *Module) writeInputs(w io.Writer) {
+	for _, name := range []string{ModFileName, "go.sum"} {
+		// a missing file is hashed as such
+		sum, _ := hashFile(filepath.Join(m.Dir, name))
+		fmt.Fprintf(w, "module %s %s\n", name, sum)
+	}
+	for _, r := range m.Replace {
+		if r.Dir == "" {
+			continue
+		}
+		sum, _ := hashFile(filepath.Join(r.Dir, ModFileName))
+		fmt.Fprintf(w, "replace %s => %s %s\n", r.Old.Path, r.Dir, sum)
+	}
+}
+
+// Contains reports whether the directory belongs to the module's tree.
+func (m *Module) Contains(path string) bool {
+	absDir, _ := filepath.Abs(m.Dir)
+	absPath, _ := filepath.Abs(path)
+	return absPath == absDir || strings.HasPrefix(absPath, absDir+string(filepath.Separator))
+}
+
+// ImportDir resolves an import path to its source directory, looking in the
+// module itself, then in replace directives and finally in the module cache.
+func (m *Module) ImportDir(imp string) (string, bool) {
+	dir := ""
+	if rest, ok := trimImportPrefix(imp, m.Path); ok {
+		dir = filepath.Join(m.Dir, filepath.FromSlash(rest))
+	} else if r := m.findReplace(imp); r != nil {
+		rest, _ := trimImportPrefix(imp, r.Old.Path)
+		if r.Dir != "" {
+			dir = filepath.Join(r.Dir, filepath.FromSlash(rest))
+		} else {
+			dir = filepath.Join(ModuleCacheDir(r.New.Path, r.New.Version), filepath.FromSlash(rest))
+		}
+	} else if req := m.findRequire(imp); req != nil {
+		rest, _ := trimImportPrefix(imp, req.Path)
+		dir = filepath.Join(ModuleCacheDir(req.Path, req.Version), filepath.FromSlash(rest))
+	} else {
+		return "", false
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", false
+	}
+	return dir, true
+}
+
+func (m *Module) findReplace(imp string) *ModuleReplace {
+	var found *ModuleReplace
+	for i, r := range m.Replace {
+		if _, ok := trimImportPrefix(imp, r.Old.Path); !ok {
+			continue
+		}
+		if r.Old.Version != "" && r.Old.Version != m.requiredVersion(r.Old.Path) {
+			continue
+		}
+		if found == nil || len(found.Old.Path) < len(r.Old.Path) {
+			found = &m.Replace[i]
+		}
+	}
+	return found
+}
+
+func (m *Module) findRequire(imp string) *ModuleVersion {
+	var found *ModuleVersion
+	for i, r := range m.Require {
+		if _, ok := trimImportPrefix(imp, r.Path); !ok {
+			continue
+		}
+		if found == nil || len(found.Path) < len(r.Path) {
+			found = &m.Require[i]
+		}
+	}
+	return found
+}
+
+func (m *Module) requiredVersion(path string) string {
+	for _, r := range m.Require {
+		if r.Path == path {
+			return r.Version
+		}
+	}
+	return ""
+}
+
+func trimImportPrefix(imp, prefix string) (string, bool) {
+	if imp == prefix {
+		return "", true
+	}
+	if strings.HasPrefix(imp, prefix+"/") {
+		return imp[len(prefix)+1:], true
+	}
+	return "", false
+}
+
+// ModuleCacheDir returns the extracted source directory of a module version
+// in GOMODCACHE.
+func ModuleCacheDir(path, version string) string {
+	return filepath.Join(moduleCacheRoot(), filepath.FromSlash(escapeModulePath(path))+"@"+escapeModulePath(version))
+}
+
+func moduleCacheRoot() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	goPath := os.Getenv("GOPATH")
+	if goPath != "" {
+		return filepath.Join(filepath.SplitList(goPath)[0], "pkg", "mod")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "go", "pkg", "mod")
+}
+
+// escapeModulePath applies the module cache's case encoding, `!` followed by
+// the lower case letter for every upper case letter.
+func escapeModulePath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if 'A' <= r && r <= 'Z' {
+			b.WriteByte('!')
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package rbgo
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseModFile(t *testing.T) {
+	data := []byte(`module github.com/kai-zoa/example // comment
+
+go 1.16
+
+require (
+	github.com/kai-zoa/geeyoko v1.0.0
+	"github.com/kai-zoa/yokohama" v1.2.0 // indirect
+)
+
+replace github.com/kai-zoa/geeyoko => ../src/vendor/github.com/kai-zoa/geeyoko
+replace (
+	github.com/kai-zoa/yokohama v1.2.0 => github.com/kai-zoa/yokohama v1.3.0
+)
+`)
+	m, err := ParseModFile("../example/mod", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := m.Path, "github.com/kai-zoa/example"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := m.Go, "1.16"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	require := []ModuleVersion{
+		{Path: "github.com/kai-zoa/geeyoko", Version: "v1.0.0"},
+		{Path: "github.com/kai-zoa/yokohama", Version: "v1.2.0"},
+	}
+	if a, e := m.Require, require; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	replace := []ModuleReplace{
+		{
+			Old: ModuleVersion{Path: "github.com/kai-zoa/geeyoko"},
+			New: ModuleVersion{Path: "../src/vendor/github.com/kai-zoa/geeyoko"},
+			Dir: "../example/src/vendor/github.com/kai-zoa/geeyoko",
+		},
+		{
+			Old: ModuleVersion{Path: "github.com/kai-zoa/yokohama", Version: "v1.2.0"},
+			New: ModuleVersion{Path: "github.com/kai-zoa/yokohama", Version: "v1.3.0"},
+		},
+	}
+	if a, e := m.Replace, replace; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestParseModFile_Error(t *testing.T) {
+	if _, err := ParseModFile(".", []byte("go 1.16\n")); err == nil {
+		t.Error("no error")
+	}
+	if _, err := ParseModFile(".", []byte("module a\nrequire (\n")); err == nil {
+		t.Error("no error")
+	}
+	if _, err := ParseModFile(".", []byte("module a\nreplace b => c\n")); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestModule_ImportDir(t *testing.T) {
+	m, err := ParseModFile("../example/mod", []byte(`module github.com/kai-zoa/example
+replace github.com/kai-zoa/geeyoko => ../src/vendor/github.com/kai-zoa/geeyoko
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, found := m.ImportDir("github.com/kai-zoa/example/hello")
+	if a, e := dir, filepath.Join("../example/mod", "hello"); !found || a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	dir, found = m.ImportDir("github.com/kai-zoa/geeyoko")
+	if a, e := dir, "../example/src/vendor/github.com/kai-zoa/geeyoko"; !found || a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if _, found := m.ImportDir("github.com/kai-zoa/example/piyo"); found {
+		t.Error("unexpected import dir")
+	}
+	if _, found := m.ImportDir("github.com/kai-zoa/yokohama"); found {
+		t.Error("unexpected import dir")
+	}
+}
+
+func TestModuleCacheDir(t *testing.T) {
+	t.Setenv("GOMODCACHE", "/tmp/mod")
+	if a, e := ModuleCacheDir("github.com/BurntSushi/toml", "v1.0.0"), "/tmp/mod/github.com/!burnt!sushi/toml@v1.0.0"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
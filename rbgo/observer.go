@@ -0,0 +1,305 @@
+package rbgo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Observer is notified of what a Watcher does. The Watcher serializes the
+// calls, so an Observer needn't be safe for concurrent use.
+type Observer interface {
+	// OnScan is called with the number of packages found in the workspace.
+	OnScan(packages int)
+	// OnWatch is called with the number of directories being watched.
+	OnWatch(dirs int)
+	OnEvent(e *Event)
+	OnBuildStart(t *Task)
+	OnBuildResult(r *BuildResult)
+	OnHook(command string)
+	OnTestResult(r *TestResult)
+	OnRestart(pkg *Package)
+	OnError(err error)
+}
+
+// NopObserver ignores everything, embed it to implement only some methods.
+type NopObserver struct{}
+
+func (NopObserver) OnScan(packages int)          {}
+func (NopObserver) OnWatch(dirs int)             {}
+func (NopObserver) OnEvent(e *Event)             {}
+func (NopObserver) OnBuildStart(t *Task)         {}
+func (NopObserver) OnBuildResult(r *BuildResult) {}
+func (NopObserver) OnHook(command string)        {}
+func (NopObserver) OnTestResult(r *TestResult)   {}
+func (NopObserver) OnRestart(pkg *Package)       {}
+func (NopObserver) OnError(err error)            {}
+
+// ConsoleObserver prints messages for people.
+type ConsoleObserver struct {
+	W io.Writer
+	// Verbose prints the objects built as well.
+	Verbose bool
+}
+
+func (c *ConsoleObserver) OnScan(packages int) {}
+
+func (c *ConsoleObserver) OnWatch(dirs int) {
+	fmt.Fprintf(c.W, "Watch %d directories\n", dirs)
+}
+
+func (c *ConsoleObserver) OnEvent(e *Event) {
+	if e.Name != EventFound {
+		fmt.Fprintf(c.W, "%s: %s\n", e.Name, e.Pacakge.WatchPath)
+	}
+}
+
+func (c *ConsoleObserver) OnBuildStart(t *Task) {}
+
+func (c *ConsoleObserver) OnBuildResult(r *BuildResult) {
+	if r.Err == nil && r.Built && c.Verbose {
+		fmt.Fprintf(c.W, "Built: %s\n", r.Task.ObjectPath)
+	}
+	if r.Err == nil || r.Err == context.Canceled {
+		return
+	}
+	if r.Task.Platform != (Platform{}) {
+		c.OnError(fmt.Errorf("%s: %s", r.Task.Platform, r.Err))
+		return
+	}
+	c.OnError(r.Err)
+}
+
+func (c *ConsoleObserver) OnHook(command string) {
+	fmt.Fprintf(c.W, "Hook: %s\n", command)
+}
+
+func (c *ConsoleObserver) OnTestResult(r *TestResult) {
+	switch {
+	case r.Err == context.Canceled:
+	case r.Err != nil:
+		c.OnError(r.Err)
+	case r.Passed:
+		fmt.Fprintf(c.W, "PASS: %s (%v)\n", r.Task.PackageName, r.Duration)
+	default:
+		fmt.Fprintf(c.W, "FAIL: %s (%v)\n%s", r.Task.PackageName, r.Duration, r.Output)
+	}
+}
+
+func (c *ConsoleObserver) OnRestart(pkg *Package) {
+	fmt.Fprintf(c.W, "Restart: %s\n", pkg.ObjectPath)
+}
+
+func (c *ConsoleObserver) OnError(err error) {
+	fmt.Fprintf(c.W, "Error: %s\n", err)
+}
+
+const (
+	RecordScan        = "scan"
+	RecordWatch       = "watch"
+	RecordFound       = "found"
+	RecordUpdate      = "update"
+	RecordDelete      = "delete"
+	RecordTestUpdate  = "test_update"
+	RecordBuildStart  = "build_start"
+	RecordBuildFinish = "build_finish"
+	RecordDiagnostic  = "diagnostic"
+	RecordHook        = "hook"
+	RecordTest        = "test"
+	RecordRestart     = "restart"
+	RecordError       = "error"
+)
+
+var eventRecords = map[EventName]string{
+	EventFound:      RecordFound,
+	EventUpdate:     RecordUpdate,
+	EventDelete:     RecordDelete,
+	EventTestUpdate: RecordTestUpdate,
+}
+
+// Record is a line of the JSONObserver output.
+type Record struct {
+	Time       time.Time   `json:"time"`
+	Event      string      `json:"event"`
+	Package    string      `json:"package,omitempty"`
+	Platform   string      `json:"platform,omitempty"`
+	Path       string      `json:"path,omitempty"`
+	Object     string      `json:"object,omitempty"`
+	Command    string      `json:"command,omitempty"`
+	Count      int         `json:"count,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	Duration   float64     `json:"duration,omitempty"` // seconds
+	Diagnostic *Diagnostic `json:"diagnostic,omitempty"`
+	Output     string      `json:"output,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+func newRecord(event string) *Record {
+	return &Record{Time: time.Now(), Event: event}
+}
+
+// JSONObserver writes a Record per line.
+type JSONObserver struct {
+	W io.Writer
+}
+
+func (j *JSONObserver) write(r *Record) {
+	json.NewEncoder(j.W).Encode(r)
+}
+
+func (j *JSONObserver) OnScan(packages int) {
+	r := newRecord(RecordScan)
+	r.Count = packages
+	j.write(r)
+}
+
+func (j *JSONObserver) OnWatch(dirs int) {
+	r := newRecord(RecordWatch)
+	r.Count = dirs
+	j.write(r)
+}
+
+func (j *JSONObserver) OnEvent(e *Event) {
+	r := newRecord(eventRecords[e.Name])
+	r.Package = e.Pacakge.FullName
+	r.Path = e.Pacakge.WatchPath
+	j.write(r)
+}
+
+func (j *JSONObserver) OnBuildStart(t *Task) {
+	r := newRecord(RecordBuildStart)
+	r.Package = t.PackageName
+	r.Platform = t.Platform.String()
+	r.Object = t.ObjectPath
+	j.write(r)
+}
+
+func (j *JSONObserver) OnBuildResult(result *BuildResult) {
+	r := newRecord(RecordBuildFinish)
+	r.Package = result.Task.PackageName
+	r.Platform = result.Task.Platform.String()
+	r.Object = result.Task.ObjectPath
+	r.Duration = result.Duration.Seconds()
+	switch result.Err {
+	case nil:
+		r.Status = "ok"
+	case context.Canceled:
+		r.Status = "canceled"
+	default:
+		r.Status = "failed"
+		r.Error = result.Err.Error()
+	}
+	j.write(r)
+	if e, ok := result.Err.(*BuildError); ok {
+		for i := range e.Diagnostics {
+			r := newRecord(RecordDiagnostic)
+			r.Package = e.Package
+			r.Platform = result.Task.Platform.String()
+			r.Diagnostic = &e.Diagnostics[i]
+			j.write(r)
+		}
+	}
+}
+
+func (j *JSONObserver) OnHook(command string) {
+	r := newRecord(RecordHook)
+	r.Command = command
+	j.write(r)
+}
+
+func (j *JSONObserver) OnTestResult(result *TestResult) {
+	if result.Err != nil && result.Err != context.Canceled {
+		j.OnError(result.Err)
+		return
+	}
+	r := newRecord(RecordTest)
+	r.Package = result.Task.PackageName
+	r.Path = result.Task.Package.WatchPath
+	r.Duration = result.Duration.Seconds()
+	r.Output = result.Output
+	switch {
+	case result.Err != nil:
+		r.Status = "canceled"
+	case result.Passed:
+		r.Status = "pass"
+	default:
+		r.Status = "fail"
+	}
+	j.write(r)
+}
+
+func (j *JSONObserver) OnRestart(pkg *Package) {
+	r := newRecord(RecordRestart)
+	r.Package = pkg.FullName
+	r.Object = pkg.ObjectPath
+	j.write(r)
+}
+
+func (j *JSONObserver) OnError(err error) {
+	r := newRecord(RecordError)
+	r.Error = err.Error()
+	j.write(r)
+}
+
+// syncObserver serializes the calls to an Observer.
+type syncObserver struct {
+	o Observer
+	m sync.Mutex
+}
+
+func (s *syncObserver) OnScan(packages int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.o.OnScan(packages)
+}
+
+func (s *syncObserver) OnWatch(dirs int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.o.OnWatch(dirs)
+}
+
+func (s *syncObserver) OnEvent(e *Event) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.o.OnEvent(e)
+}
+
+func (s *syncObserver) OnBuildStart(t *Task) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.o.OnBuildStart(t)
+}
+
+func (s *syncObserver) OnBuildResult(r *BuildResult) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.o.OnBuildResult(r)
+}
+
+func (s *syncObserver) OnHook(command string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.o.OnHook(command)
+}
+
+func (s *syncObserver) OnTestResult(r *TestResult) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.o.OnTestResult(r)
+}
+
+func (s *syncObserver) OnRestart(pkg *Package) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.o.OnRestart(pkg)
+}
+
+func (s *syncObserver) OnError(err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.o.OnError(err)
+}
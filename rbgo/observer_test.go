@@ -0,0 +1,98 @@
+package rbgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONObserver(t *testing.T) {
+	out := new(bytes.Buffer)
+	o := &JSONObserver{W: out}
+	pkg := &Package{FullName: "hoge/piyo", WatchPath: "src/hoge/piyo"}
+	task := &Task{PackageName: "hoge/piyo", ObjectPath: "pkg/hoge/piyo.a", Package: pkg}
+	o.OnEvent(&Event{Name: EventUpdate, Pacakge: pkg})
+	o.OnBuildResult(&BuildResult{
+		Task: task,
+		Err: &BuildError{
+			Package:     "hoge/piyo",
+			Output:      "src/hoge/piyo/piyo.go:3:1: undefined: x\n",
+			Diagnostics: []Diagnostic{{Package: "hoge/piyo", File: "/work/src/hoge/piyo/piyo.go", Line: 3, Column: 1, Message: "undefined: x"}},
+		},
+	})
+	o.OnTestResult(&TestResult{Task: task, Passed: true, Duration: time.Second})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	events := []string{}
+	records := []*Record{}
+	for _, line := range lines {
+		r := &Record{}
+		if err := json.Unmarshal([]byte(line), r); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, r.Event)
+		records = append(records, r)
+	}
+	if a, e := strings.Join(events, " "), "update build_finish diagnostic test"; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := records[0].Path, "src/hoge/piyo"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := records[1].Status, "failed"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := records[2].Diagnostic.Line, 3; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := records[3].Status, "pass"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestConsoleObserver(t *testing.T) {
+	out := new(bytes.Buffer)
+	o := &ConsoleObserver{W: out}
+	pkg := &Package{FullName: "hoge/piyo", WatchPath: "src/hoge/piyo"}
+	o.OnEvent(&Event{Name: EventFound, Pacakge: pkg})
+	o.OnEvent(&Event{Name: EventUpdate, Pacakge: pkg})
+	o.OnError(fmt.Errorf("oops"))
+	if a, e := out.String(), "Update: src/hoge/piyo\nError: oops\n"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+type countObserver struct {
+	NopObserver
+	results int
+}
+
+func (c *countObserver) OnBuildResult(r *BuildResult) {
+	c.results++
+}
+
+func TestWatcher_Observer(t *testing.T) {
+	repo, pkgs := newExampleRepository()
+	ws := &Workspace{Package: repo}
+	o := &countObserver{}
+	w := &Watcher{Workspace: ws, Observer: o, Log: new(bytes.Buffer)}
+	w.applyConfig()
+	results := w.runTasks(context.Background(), pkgs[:1])
+	for _, r := range results {
+		defer os.Remove(r.Task.ObjectPath)
+	}
+	if a, e := o.results, 3; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
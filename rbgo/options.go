@@ -0,0 +1,186 @@
+package rbgo
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// StampData is what the LDFlags template of BuildOptions is expanded with.
+type StampData struct {
+	// Package is the import path of the command.
+	Package string
+	GOOS    string
+	GOARCH  string
+	// Commit is the HEAD of the git repository, empty outside of one.
+	Commit string
+	// Version is `git describe --tags --always --dirty`.
+	Version string
+	// Time is the build time, RFC 3339 in UTC.
+	Time string
+}
+
+// Merge returns the options of a package, o overridden by p: the flags, the
+// tags and the env are added, the switches, gcflags and ldflags p sets win.
+func (o BuildOptions) Merge(p *BuildOptions) BuildOptions {
+	if p == nil {
+		return o
+	}
+	merged := o
+	merged.Flags = append(append([]string(nil), o.Flags...), p.Flags...)
+	merged.Tags = append(append([]string(nil), o.Tags...), p.Tags...)
+	if p.Race != nil {
+		merged.Race = p.Race
+	}
+	if p.TrimPath != nil {
+		merged.TrimPath = p.TrimPath
+	}
+	if p.GCFlags != "" {
+		merged.GCFlags = p.GCFlags
+	}
+	if p.LDFlags != "" {
+		merged.LDFlags = p.LDFlags
+	}
+	if o.Env != nil || p.Env != nil {
+		merged.Env = map[string]string{}
+		for key, value := range o.Env {
+			merged.Env[key] = value
+		}
+		for key, value := range p.Env {
+			merged.Env[key] = value
+		}
+	}
+	return merged
+}
+
+// flags returns the `go build` flags of the options, ldflags linking
+// commands only.
+func (o *BuildOptions) flags(command bool, ldflags string) []string {
+	flags := append([]string{}, o.Flags...)
+	if len(o.Tags) > 0 {
+		// the last -tags flag wins, so it repeats the tags of Flags
+		flags = append(flags, "-tags="+strings.Join(o.tags(), ","))
+	}
+	if o.race() {
+		flags = append(flags, "-race")
+	}
+	if o.GCFlags != "" {
+		flags = append(flags, "-gcflags="+o.GCFlags)
+	}
+	if command && ldflags != "" {
+		flags = append(flags, "-ldflags="+ldflags)
+	}
+	if o.TrimPath != nil && *o.TrimPath {
+		flags = append(flags, "-trimpath")
+	}
+	return flags
+}
+
+func (o *BuildOptions) race() bool {
+	return o.Race != nil && *o.Race
+}
+
+func parseLDFlags(ldflags string) (*template.Template, error) {
+	return template.New("ldflags").Option("missingkey=error").Parse(ldflags)
+}
+
+// ExpandLDFlags expands the LDFlags template with data.
+func (o *BuildOptions) ExpandLDFlags(data StampData) (string, error) {
+	tmpl, err := parseLDFlags(o.LDFlags)
+	if err != nil {
+		return "", err
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// stampData returns the stamp of the command built at now. Git runs only
+// if the template refers to its fields.
+func (t *Task) stampData(now time.Time) StampData {
+	o := t.Package.Options
+	if o == nil || !t.Package.IsCommand || o.LDFlags == "" {
+		return StampData{}
+	}
+	ctxt := t.Platform.buildContext(t.Package.BuildContext)
+	data := StampData{
+		Package: t.Package.FullName,
+		GOOS:    ctxt.GOOS,
+		GOARCH:  ctxt.GOARCH,
+	}
+	if !now.IsZero() {
+		data.Time = now.UTC().Format(time.RFC3339)
+	}
+	if strings.Contains(o.LDFlags, ".Commit") {
+		data.Commit = t.git("rev-parse", "HEAD")
+	}
+	if strings.Contains(o.LDFlags, ".Version") {
+		data.Version = t.git("describe", "--tags", "--always", "--dirty")
+	}
+	return data
+}
+
+// ldflags returns the expanded LDFlags of a command built at now.
+func (t *Task) ldflags(now time.Time) (string, error) {
+	if !t.Package.IsCommand || t.Package.Options.LDFlags == "" {
+		return "", nil
+	}
+	return t.Package.Options.ExpandLDFlags(t.stampData(now))
+}
+
+// git returns the trimmed output of git in the package's working directory,
+// empty on error, looked up once per build run.
+func (t *Task) git(arguments ...string) string {
+	return t.stamps.git(t.Package.WorkDir, arguments...)
+}
+
+// stampCache holds the git output of a build run, so it's looked up once per
+// directory rather than on every hash of a stamped command.
+type stampCache struct {
+	outputs map[string]string
+	m       sync.Mutex
+}
+
+func newStampCache() *stampCache {
+	return &stampCache{outputs: map[string]string{}}
+}
+
+// git runs git in dir, uncached if c is nil.
+func (c *stampCache) git(dir string, arguments ...string) string {
+	if c == nil {
+		return runGit(dir, arguments...)
+	}
+	key := dir + "\x00" + strings.Join(arguments, "\x00")
+	c.m.Lock()
+	defer c.m.Unlock()
+	output, found := c.outputs[key]
+	if !found {
+		output = runGit(dir, arguments...)
+		c.outputs[key] = output
+	}
+	return output
+}
+
+func runGit(dir string, arguments ...string) string {
+	command := exec.Command("git", arguments...)
+	command.Dir = dir
+	output, err := command.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package rbgo
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestBuildOptions_Merge(t *testing.T) {
+	o := BuildOptions{
+		Flags:   []string{"-v"},
+		Tags:    []string{"foo"},
+		GCFlags: "-N -l",
+		LDFlags: "-s",
+		Env:     map[string]string{"CGO_ENABLED": "0", "GOFLAGS": "-mod=mod"},
+	}
+	merged := o.Merge(&BuildOptions{
+		Tags:     []string{"bar"},
+		Race:     boolPtr(true),
+		LDFlags:  "-X main.version={{.Version}}",
+		TrimPath: boolPtr(true),
+		Env:      map[string]string{"CGO_ENABLED": "1"},
+	})
+	e := BuildOptions{
+		Flags:    []string{"-v"},
+		Tags:     []string{"foo", "bar"},
+		Race:     boolPtr(true),
+		GCFlags:  "-N -l",
+		LDFlags:  "-X main.version={{.Version}}",
+		TrimPath: boolPtr(true),
+		Env:      map[string]string{"CGO_ENABLED": "1", "GOFLAGS": "-mod=mod"},
+	}
+	if a := merged; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := o.Env["CGO_ENABLED"], "0"; a != e {
+		err := "modified"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := merged.flags(true, "-s -w"), []string{"-v", "-tags=foo,bar", "-race", "-gcflags=-N -l", "-ldflags=-s -w", "-trimpath"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	// archives aren't linked
+	if a, e := merged.flags(false, "-s -w"), []string{"-v", "-tags=foo,bar", "-race", "-gcflags=-N -l", "-trimpath"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	// a package turns off the switches of the workspace
+	off := merged.Merge(&BuildOptions{Race: boolPtr(false), TrimPath: boolPtr(false)})
+	if a, e := off.flags(false, ""), []string{"-v", "-tags=foo,bar", "-gcflags=-N -l"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestBuildOptions_ExpandLDFlags(t *testing.T) {
+	o := BuildOptions{LDFlags: "-X main.version={{.Version}} -X main.built={{.Time}} -X main.pkg={{.Package}}"}
+	a, err := o.ExpandLDFlags(StampData{Package: "example.com/cmd", Version: "v1.0.0", Time: "2006-01-02T15:04:05Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := "-X main.version=v1.0.0 -X main.built=2006-01-02T15:04:05Z -X main.pkg=example.com/cmd"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	o.LDFlags = "-X main.version={{.Tag}}"
+	if _, err := o.ExpandLDFlags(StampData{}); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestTask_Build_Options(t *testing.T) {
+	ws := newTempModule(t, map[string]string{
+		"hello/main.go":  "package main\n\nvar version string\n\nfunc main() { println(version + edition) }\n",
+		"hello/plain.go": "//go:build !pro\n\npackage main\n\nconst edition = \" plain\"\n",
+		"hello/pro.go":   "//go:build pro\n\npackage main\n\nconst edition = \" pro\"\n",
+	})
+	ws.Options.LDFlags = "-X main.version={{.Package}}@{{.GOOS}}"
+	ws.PackageOptions["example.com/temp/hello"] = &BuildOptions{Tags: []string{"pro"}}
+	dir := filepath.Join(ws.root, "hello")
+	pkg := ws.NewPackage(dir)
+	if err := ws.Scan(pkg); err != nil {
+		t.Fatal(err)
+	}
+	ws.Package.Put(pkg)
+	if a, e := pkg.ExcludedFiles, []string{filepath.Join(dir, "plain.go")}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	factory := TaskFactory{Package: ws.Package}
+	task, err := factory.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Build(); err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command(task.ObjectPath).CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := strings.TrimSpace(string(output)), "example.com/temp/hello@"+DefaultPlatform().GOOS+" pro"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := task.Stale(), false; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	// changing the flags makes the object stale
+	pkg.Options.TrimPath = boolPtr(true)
+	if a, e := task.Stale(), true; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestPackageRepository_BuildPlans_Stamps(t *testing.T) {
+	ws := newTempModule(t, map[string]string{
+		"a/main.go": "package main\n\nvar commit string\n\nfunc main() { println(commit) }\n",
+		"b/main.go": "package main\n\nvar commit string\n\nfunc main() { println(commit) }\n",
+	})
+	ws.Options.LDFlags = "-X main.commit={{.Commit}}"
+	platforms := []Platform{{}, {GOOS: "windows", GOARCH: "amd64"}}
+	// the hashes of the built commands are stamped
+	report, err := ws.Package.BuildMatrix(ws.Package.All(), platforms, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("build failed: %v", report.Failed())
+	}
+	plans, err := ws.Package.BuildPlans(ws.Package.All(), platforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plans[0].stamps != plans[1].stamps {
+		t.Fatal("plans of a run don't share the stamps")
+	}
+	// git ran once for both commands and platforms
+	if a, e := len(plans[0].stamps.outputs), 1; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := len(plans[0].Packages)+len(plans[1].Packages), 0; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
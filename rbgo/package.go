@@ -3,34 +3,40 @@ package rbgo
 import (
 	"time"
 	"io/ioutil"
+	"path"
 	"path/filepath"
 	"strings"
 	"go/token"
 	"go/parser"
 	"fmt"
 	"go/ast"
+	"go/build"
 	"regexp"
 	"os"
 	"runtime"
 	"errors"
+	"sync"
 )
 
 var (
 	SourceNotFound = errors.New("SourceNotFound")
 	PackageDirName = ""
+	buildOS        = runtime.GOOS
+	buildArch      = runtime.GOARCH
 )
 
 func init() {
 	goOS, goArch := runtime.GOOS, runtime.GOARCH
 	for _, e := range os.Environ() {
-		pair := strings.Split(e, "=")
-		if pair[0] == "GOOS" {
+		pair := strings.SplitN(e, "=", 2)
+		if pair[0] == "GOOS" && pair[1] != "" {
 			goOS = pair[1]
 		}
-		if pair[1] == "GOARCH" {
+		if pair[0] == "GOARCH" && pair[1] != "" {
 			goArch = pair[1]
 		}
 	}
+	buildOS, buildArch = goOS, goArch
 	PackageDirName = filepath.Join("pkg", fmt.Sprintf("%s_%s", goOS, goArch))
 }
 
@@ -38,42 +44,86 @@ func IsGoSource(path string) bool {
 	return strings.HasSuffix(path, ".go") && !strings.HasSuffix(path, "_test.go")
 }
 
+func IsGoTestSource(path string) bool {
+	return strings.HasSuffix(path, "_test.go")
+}
+
+// ScanTestFiles returns the test files in the directory satisfying the build
+// constraints of ctxt, the default context if nil, and the excluded ones.
+func ScanTestFiles(ctxt *build.Context, path string) ([]string, []string, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, fi := range files {
+		if !fi.IsDir() {
+			names = append(names, fi.Name())
+		}
+	}
+	tests, excluded, err := matchFiles(ctxt, path, names, IsGoTestSource)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, name := range tests {
+		tests[i] = filepath.Join(path, name)
+	}
+	for i, name := range excluded {
+		excluded[i] = filepath.Join(path, name)
+	}
+	return tests, excluded, nil
+}
+
 type Source struct {
+	path        string
 	packageName string
 	modTime     time.Time
 	imports     []string
 }
 
-func ScanSources(path string) ([]Source, error) {
+// ScanSources parses the sources in the directory satisfying the build
+// constraints of ctxt, the default context if nil, and returns the paths of
+// the excluded ones.
+func ScanSources(ctxt *build.Context, path string) ([]Source, []string, error) {
 	files, err := ioutil.ReadDir(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	name := ""
-	sources := make([]Source, 0, len(files))
+	infos := make(map[string]os.FileInfo, len(files))
+	names := make([]string, 0, len(files))
 	for _, fi := range files {
-		fpath := filepath.Join(path, fi.Name())
-		if !IsGoSource(fpath) {
-			continue
+		if !fi.IsDir() {
+			infos[fi.Name()] = fi
+			names = append(names, fi.Name())
 		}
+	}
+	matched, excluded, err := matchFiles(ctxt, path, names, IsGoSource)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, name := range excluded {
+		excluded[i] = filepath.Join(path, name)
+	}
+	name := ""
+	sources := make([]Source, 0, len(matched))
+	for _, file := range matched {
+		fi := infos[file]
+		fpath := filepath.Join(path, file)
 		fs := token.NewFileSet()
 		astFile, err := parser.ParseFile(fs, fpath, nil, parser.ImportsOnly)
 		if err != nil {
-			return nil, err
-		}
-		if astFile.Name.Name == "main" {
-			continue
+			return nil, nil, err
 		}
 		if name != "" && name != astFile.Name.Name {
-			return nil, fmt.Errorf("found multiple packages %s, %s ...", name, astFile.Name.Name)
+			return nil, nil, fmt.Errorf("found multiple packages %s, %s ...", name, astFile.Name.Name)
 		}
+		name = astFile.Name.Name
 		src := Source{
+			path: fpath,
 			packageName: name,
 			modTime: fi.ModTime(),
 			imports: []string{},
 		}
-		sources = append(sources, src)
-		name = astFile.Name.Name
 		for _, decl := range astFile.Decls {
 			if gd, ok := decl.(*ast.GenDecl); ok {
 				if gd.Tok == token.IMPORT {
@@ -85,8 +135,9 @@ func ScanSources(path string) ([]Source, error) {
 				}
 			}
 		}
+		sources = append(sources, src)
 	}
-	return sources, nil
+	return sources, excluded, nil
 }
 
 func NewPackage(sourceRoot, watchPath string) *Package {
@@ -105,11 +156,22 @@ type Package struct {
 	InVendor       bool
 	sourceRoot     string
 	SourceCount    int
+	Files          []string
+	TestFiles      []string
+	// ExcludedFiles are the sources and test files excluded by the build
+	// constraints.
+	ExcludedFiles  []string
+	// BuildContext is the context the build constraints are evaluated
+	// against, the default context if nil.
+	BuildContext   *build.Context
 	WatchPath      string
 	SourcePath     string
 	ObjectPath     string
 	WorkDir        string
 	ProjectName    string
+	IsCommand      bool
+	Module         *Module
+	Options        *BuildOptions
 	ModTime        time.Time
 	Imports        []string
 	Referrers      []*Package
@@ -127,18 +189,29 @@ func (p *Package) Scan(f PackageRootFinder) error {
 	p.ObjectPath = ""
 	p.WorkDir = ""
 	p.ProjectName = ""
+	p.IsCommand = false
 	p.SourceCount = 0
+	p.Files = []string{}
+	p.TestFiles = []string{}
+	p.ExcludedFiles = []string{}
 	p.ModTime = time.Time{}
 	name := ""
 	// Scan Sources
-	sources, err := ScanSources(p.WatchPath)
+	sources, excluded, err := ScanSources(p.BuildContext, p.WatchPath)
 	if err != nil {
 		return err
 	}
+	p.ExcludedFiles = excluded
 	p.SourceCount = len(sources)
 	if p.SourceCount == 0 {
 		return SourceNotFound
 	}
+	tests, excluded, err := ScanTestFiles(p.BuildContext, p.WatchPath)
+	if err != nil {
+		return err
+	}
+	p.TestFiles = tests
+	p.ExcludedFiles = append(p.ExcludedFiles, excluded...)
 	imports := make([]string, 0, len(p.Imports))
 	for _, s := range sources {
 		name = s.packageName
@@ -147,33 +220,50 @@ func (p *Package) Scan(f PackageRootFinder) error {
 			p.ModTime = t
 		}
 		imports = append(imports, s.imports...)
+		p.Files = append(p.Files, s.path)
 	}
 	//
 	p.Name = name
 	p.FullName = name
 	p.Imports = imports
+	p.IsCommand = name == "main"
 	vendorEntry := filepath.Join(p.sourceRoot, "vendor")
-	absSourceRoot, _ := filepath.Abs(p.sourceRoot)
+	importRoot, importPrefix := p.sourceRoot, ""
+	if p.Module != nil {
+		vendorEntry = filepath.Join(p.Module.Dir, "vendor")
+		importRoot, importPrefix = p.Module.Dir, p.Module.Path
+	}
+	absImportRoot, _ := filepath.Abs(importRoot)
 	absWatchPath, _ := filepath.Abs(p.WatchPath)
 	absVendorPath, _ := filepath.Abs(vendorEntry)
-	if strings.HasPrefix(p.WatchPath, vendorEntry) {
+	if strings.HasPrefix(absWatchPath, absVendorPath + string(filepath.Separator)) {
 
-		p.FullName = absWatchPath[len(absVendorPath) + 1:]
+		p.FullName = filepath.ToSlash(absWatchPath[len(absVendorPath) + 1:])
 		p.ProjectName, err = f.VendorProjectName(vendorEntry, p.FullName)
 		if err != nil {
 			return err
 		}
 		p.SourcePath = filepath.Join(vendorEntry, filepath.Join(strings.Split(p.ProjectName, "/")...))
 		p.InVendor = true
+		// commands in vendor are not build targets
+		if p.IsCommand {
+			return SourceNotFound
+		}
 
 	} else {
-		p.FullName = absWatchPath[len(absSourceRoot) + 1:]
+		rel, err := filepath.Rel(absImportRoot, absWatchPath)
+		if err != nil {
+			return err
+		}
+		p.FullName = path.Join(importPrefix, filepath.ToSlash(rel))
 		p.SourcePath = p.WatchPath
 	}
 	pkgDir := PackageDirName
 	objectEntry := filepath.Join(p.sourceRoot, pkgDir)
 	wd := p.sourceRoot
-	if strings.HasSuffix(p.sourceRoot, "src") {
+	if p.Module != nil {
+		wd = p.Module.Dir
+	} else if strings.HasSuffix(p.sourceRoot, "src") {
 		i := strings.LastIndex(p.sourceRoot, "/src")
 		if i != -1 {
 			wd = p.sourceRoot[:i]
@@ -184,6 +274,10 @@ func (p *Package) Scan(f PackageRootFinder) error {
 		}
 	}
 	p.WorkDir, _ = filepath.Abs(wd)
+	if p.IsCommand {
+		p.ObjectPath = DefaultBinaryPath(wd, p.FullName)
+		return nil
+	}
 	if p.InVendor {
 		objectEntry = filepath.Join(objectEntry, "vendor")
 		p.ObjectPath = filepath.Join(objectEntry, filepath.Join(strings.Split(p.ProjectName, "/")...))
@@ -194,23 +288,64 @@ func (p *Package) Scan(f PackageRootFinder) error {
 	return nil
 }
 
-// PackageRepository
+// DefaultBinaryPath returns where the command is installed unless the
+// workspace configures otherwise, `bin/<last element of the import path>`.
+func DefaultBinaryPath(workDir, fullName string) string {
+	name := path.Base(fullName)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(workDir, "bin", name)
+}
+
+// PackageRepository is safe for concurrent use. The exported methods lock
+// it, the unexported ones expect the caller to hold m. The packages in it are
+// rescanned with m locked, so their fields are read with m read-locked.
+//
+// Put and Delete keep the import graph up to date, relinking only the
+// packages whose imports are affected.
 type PackageRepository struct {
 	nameToPkg map[string]*Package
 	pathToPkg map[string]*Package
 	dirToPkgs map[string][]*Package
-	extPrj    map[string]*PackageRepository
+	// extPrj are the vendored packages of every project
+	extPrj    map[string]map[*Package]bool
+	links     map[*Package]*dependLinks
+	// outside are the importers of every path not in the repository
+	outside   map[string]map[*Package]bool
+	goRoot    map[string]bool
+	Manifest  *Manifest
+	Cache     *Cache
+	m         sync.RWMutex
 }
 
 func (r *PackageRepository) Init() *PackageRepository {
 	r.nameToPkg = make(map[string]*Package)
 	r.pathToPkg = make(map[string]*Package)
 	r.dirToPkgs = make(map[string][]*Package)
-	r.extPrj = map[string]*PackageRepository{}
+	r.extPrj = map[string]map[*Package]bool{}
+	r.links = map[*Package]*dependLinks{}
+	r.outside = map[string]map[*Package]bool{}
+	r.goRoot = map[string]bool{}
 	return r
 }
 
+// rlock read-locks the repository, if any, and returns the unlock.
+func (r *PackageRepository) rlock() func() {
+	if r == nil {
+		return func() {}
+	}
+	r.m.RLock()
+	return r.m.RUnlock
+}
+
 func (r *PackageRepository) All() []*Package {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.all()
+}
+
+func (r *PackageRepository) all() []*Package {
 	all := make([]*Package, 0, len(r.pathToPkg))
 	for _, pkg := range r.pathToPkg {
 		all = append(all, pkg)
@@ -218,7 +353,25 @@ func (r *PackageRepository) All() []*Package {
 	return all
 }
 
+func (r *PackageRepository) Commands() []*Package {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	commands := []*Package{}
+	for _, pkg := range r.pathToPkg {
+		if pkg.IsCommand {
+			commands = append(commands, pkg)
+		}
+	}
+	return commands
+}
+
 func (r *PackageRepository) FindByPath(path string) *Package {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.findByPath(path)
+}
+
+func (r *PackageRepository) findByPath(path string) *Package {
 	pkg, found := r.pathToPkg[path]
 	if !found {
 		return nil
@@ -227,6 +380,12 @@ func (r *PackageRepository) FindByPath(path string) *Package {
 }
 
 func (r *PackageRepository) FindByImportName(imp string) *Package {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.findByImportName(imp)
+}
+
+func (r *PackageRepository) findByImportName(imp string) *Package {
 	pkg, found := r.nameToPkg[imp]
 	if !found {
 		return nil
@@ -234,92 +393,148 @@ func (r *PackageRepository) FindByImportName(imp string) *Package {
 	return pkg
 }
 
+func (r *PackageRepository) FindByObjectPath(path string) []*Package {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.findByObjectPath(path)
+}
+
+func (r *PackageRepository) findByObjectPath(path string) []*Package {
+	pkgs := []*Package{}
+	for _, pkg := range r.pathToPkg {
+		if pkg.ObjectPath == path {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs
+}
+
 func (r *PackageRepository) FindByDir(dir string) []*Package {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.findByDir(dir)
+}
+
+func (r *PackageRepository) findByDir(dir string) []*Package {
 	p, found := r.dirToPkgs[dir]
 	if found {
-		return p
+		return append([]*Package{}, p...)
 	}
 	return []*Package{}
 }
 
 func (r *PackageRepository) Put(pkg *Package) {
-	dir := filepath.Dir(pkg.WatchPath)
-	pkgs, found := r.dirToPkgs[dir]
-	if found {
-		pkgs = append(pkgs, pkg)
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.put(pkg)
+}
+
+func (r *PackageRepository) put(pkg *Package) {
+	referrers := []*Package{}
+	if old, found := r.links[pkg]; found {
+		r.unlink(pkg)
+		if old.name != pkg.FullName {
+			// the packages importing the old name lose it
+			referrers = pkg.Referrers
+			pkg.Referrers = []*Package{}
+			if r.nameToPkg[old.name] == pkg {
+				delete(r.nameToPkg, old.name)
+			}
+		}
 	} else {
-		pkgs = []*Package{pkg}
+		dir := filepath.Dir(pkg.WatchPath)
+		r.dirToPkgs[dir] = append(r.dirToPkgs[dir], pkg)
 	}
-	r.dirToPkgs[dir] = pkgs
 	r.pathToPkg[pkg.WatchPath] = pkg
 	r.nameToPkg[pkg.FullName] = pkg
+	r.link(pkg)
+	for _, ref := range referrers {
+		r.relink(ref)
+	}
+	for ref := range r.outside[pkg.FullName] {
+		r.relink(ref)
+	}
 }
 
 func (r *PackageRepository) Delete(pkg *Package) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.delete(pkg)
+}
+
+func (r *PackageRepository) delete(pkg *Package) {
+	name := pkg.FullName
+	if l, found := r.links[pkg]; found {
+		name = l.name
+		r.unlink(pkg)
+	}
 	dir := filepath.Dir(pkg.WatchPath)
 	pkgs, found := r.dirToPkgs[dir]
 	if found {
 		for i, p := range pkgs {
 			if p == pkg {
-				r.dirToPkgs[dir] = append(pkgs[:i], pkgs[i + 1:]...)
+				r.dirToPkgs[dir] = append(pkgs[:i:i], pkgs[i + 1:]...)
 				break
 			}
 		}
 	}
-	delete(r.pathToPkg, pkg.WatchPath)
-	delete(r.nameToPkg, pkg.FullName)
+	if r.pathToPkg[pkg.WatchPath] == pkg {
+		delete(r.pathToPkg, pkg.WatchPath)
+	}
+	if r.nameToPkg[name] == pkg {
+		delete(r.nameToPkg, name)
+	}
+	referrers := pkg.Referrers
+	pkg.Referrers = []*Package{}
+	for _, ref := range referrers {
+		r.relink(ref)
+	}
 }
 
 func (r *PackageRepository) ProjectReferrers(pn string) []*Package {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.projectReferrers(pn)
+}
+
+func (r *PackageRepository) projectReferrers(pn string) []*Package {
 	pkgs := []*Package{}
-	repo, found := r.extPrj[pn]
-	if !found {
-		return pkgs
-	}
-	for _, pkg := range repo.All() {
+	for pkg := range r.extPrj[pn] {
 		pkgs = append(pkgs, pkg.Referrers...)
 	}
 	return pkgs
 }
 
-func (r *PackageRepository) UpdateDepends() {
-	goPath := []string{filepath.Join(runtime.GOROOT(), "src")}
-	//fmt.Printf("%v\n", goPath)
-	r.extPrj = make(map[string]*PackageRepository, len(r.extPrj))
-	for _, pkg := range r.All() {
-		pkg.MissingImports = make([]string, 0, len(pkg.MissingImports))
-		for _, imp := range pkg.Imports {
-			if imp == "C" || imp == "appengine/cloudsql" {
-				continue
-			}
-			ref := r.FindByImportName(imp)
-			if ref != nil {
-				ref.Referrers = append(ref.Referrers, pkg)
-				continue
-			}
-			found := false
-			for _, path := range goPath {
-				list := []string{path}
-				list = append(list, strings.Split(imp, "/")...)
-				impPath := filepath.Join(list...)
-				if _, err := os.Stat(impPath); err == nil {
-					found = true
-					break
-				}
-			}
-			if !found {
-				pkg.MissingImports = append(pkg.MissingImports, imp)
-			}
+// ReverseDepends returns pkgs and the transitive closure of the packages
+// importing them, including the referrers of vendored projects.
+func (r *PackageRepository) ReverseDepends(pkgs []*Package) []*Package {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.reverseDepends(pkgs)
+}
+
+func (r *PackageRepository) reverseDepends(pkgs []*Package) []*Package {
+	closure := []*Package{}
+	visited := map[*Package]bool{}
+	var visit func(pkg *Package)
+	visit = func(pkg *Package) {
+		if visited[pkg] {
+			return
 		}
+		visited[pkg] = true
+		closure = append(closure, pkg)
+		referrers := pkg.Referrers
 		if pkg.InVendor {
-			repo, found := r.extPrj[pkg.ProjectName]
-			if !found {
-				repo = new(PackageRepository).Init()
-			}
-			repo.Put(pkg)
-			r.extPrj[pkg.ProjectName] = repo
+			referrers = r.projectReferrers(pkg.ProjectName)
 		}
+		for _, ref := range referrers {
+			visit(ref)
+		}
+	}
+	for _, pkg := range pkgs {
+		visit(pkg)
 	}
+	return closure
 }
 
 // PackageRootFinder
@@ -338,7 +553,7 @@ func (f PackageRootFinder) VendorProjectName(vendorEntry, packageName string) (s
 	projectName := f.Find(packageName)
 	if projectName != packageName {
 		packageRoot := filepath.Join(vendorEntry, projectName)
-		sources, err := ScanSources(packageRoot)
+		sources, _, err := ScanSources(nil, packageRoot)
 		if err != nil {
 			return "", err
 		}
@@ -349,7 +564,7 @@ func (f PackageRootFinder) VendorProjectName(vendorEntry, packageName string) (s
 				if nextDir == packageRoot {
 					break
 				}
-				sources, err := ScanSources(nextDir)
+				sources, _, err := ScanSources(nil, nextDir)
 				if err != nil {
 					return "", err
 				}
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"time"
 	"path/filepath"
+	"sync"
 )
 
 func TestPackage_Fresh(t *testing.T) {
@@ -141,6 +142,67 @@ func TestPackage_Fresh_Vendor_HasDep(t *testing.T) {
 	}
 }
 
+func TestPackage_Fresh_Module(t *testing.T) {
+	w, err := NewWorkspace("../example/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := w.ModuleMode(), true; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	pkg := w.NewPackage("../example/mod/greeting")
+	pkg.Scan(w.PackageRoot)
+	if a, e := pkg.Name, "greeting"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := pkg.FullName, "github.com/kai-zoa/example/greeting"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := pkg.InVendor, false; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := pkg.ObjectPath, filepath.Join("../example/mod", PackageDirName, "github.com/kai-zoa/example/greeting.a"); a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	wd, _ := filepath.Abs("../example/mod")
+	if a, e := pkg.WorkDir, wd; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := pkg.Imports, []string{"strings", "github.com/kai-zoa/example/hello"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestPackageRepository_Fresh_Module(t *testing.T) {
+	w, err := NewWorkspace("../example/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	hello := w.Package.FindByImportName("github.com/kai-zoa/example/hello")
+	greeting := w.Package.FindByImportName("github.com/kai-zoa/example/greeting")
+	if hello == nil || greeting == nil {
+		t.Fatal("pkg not found")
+	}
+	if a, e := hello.Referrers, []*Package{greeting}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := greeting.MissingImports, []string{}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
 func TestPackageRepository_Fresh(t *testing.T) {
 	finder := PackageRootFinder([]*regexp.Regexp{})
 	finder = append(finder, regexp.MustCompile("github.com/[a-zA-Z0-9_-]+/[a-zA-Z0-9_-]+"))
@@ -178,3 +240,74 @@ func TestPackageRepository_Fresh(t *testing.T) {
 		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
 	}
 }
+
+func TestPackage_Fresh_Command(t *testing.T) {
+	w, err := NewWorkspace("../example/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := w.NewPackage("../example/mod/cmd/greet")
+	if err := w.Scan(pkg); err != nil {
+		t.Fatal(err)
+	}
+	if a, e := pkg.Name, "main"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := pkg.IsCommand, true; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := pkg.FullName, "github.com/kai-zoa/example/cmd/greet"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := pkg.ObjectPath, DefaultBinaryPath("../example/mod", "greet"); a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	w.SetBinaryPath("github.com/kai-zoa/example/cmd/greet", "bin/greeter")
+	if err := w.Scan(pkg); err != nil {
+		t.Fatal(err)
+	}
+	if a, e := pkg.ObjectPath, "../example/mod/bin/greeter"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestPackageRepository_Concurrent(t *testing.T) {
+	repo, pkgs := newExampleRepository()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				repo.FindByPath(pkgs[0].WatchPath)
+				repo.FindByImportName("github.com/kai-zoa/yokohama")
+				repo.ReverseDepends(pkgs[2:])
+				repo.TestTargets(pkgs, true)
+				if _, err := repo.BuildPlan(pkgs[:1]); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 50; j++ {
+		repo.Delete(pkgs[1])
+		repo.Put(pkgs[1])
+		repo.UpdateDepends()
+	}
+	wg.Wait()
+	if a, e := len(repo.All()), len(pkgs); a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	// geeyoko and yokohama share the directory
+	if a, e := repo.FindByDir(filepath.Dir(pkgs[1].WatchPath)), []*Package{pkgs[2], pkgs[1]}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
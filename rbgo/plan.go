@@ -0,0 +1,150 @@
+package rbgo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ImportCycleError names the packages of an import cycle, the first package
+// repeated at the end.
+type ImportCycleError struct {
+	Cycle []string
+}
+
+func (e *ImportCycleError) Error() string {
+	return fmt.Sprintf("import cycle not allowed: %s", strings.Join(e.Cycle, " -> "))
+}
+
+// BuildPlan lists the stale packages in build order, every package after the
+// packages it imports.
+type BuildPlan struct {
+	Packages []*Package
+	// Platform is the target the packages are stale for.
+	Platform Platform
+	imports  map[*Package][]*Package
+	// stamps are shared by the plans of a run and the tasks building them
+	stamps   *stampCache
+}
+
+// Imports returns the packages in the plan which must be built before pkg.
+func (p *BuildPlan) Imports(pkg *Package) []*Package {
+	return p.imports[pkg]
+}
+
+// BuildPlan computes the build order of the stale packages among targets and
+// their transitive imports. A package is stale when its object is out of date
+// or any package it imports is stale.
+func (r *PackageRepository) BuildPlan(targets []*Package) (*BuildPlan, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.buildPlan(targets, Platform{}, newStampCache())
+}
+
+// BuildPlans computes a BuildPlan for every platform, the default platform if
+// none.
+func (r *PackageRepository) BuildPlans(targets []*Package, platforms []Platform) ([]*BuildPlan, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	if len(platforms) == 0 {
+		platforms = []Platform{{}}
+	}
+	plans := make([]*BuildPlan, 0, len(platforms))
+	stamps := newStampCache()
+	for _, platform := range platforms {
+		plan, err := r.buildPlan(targets, platform, stamps)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+func (r *PackageRepository) buildPlan(targets []*Package, platform Platform, stamps *stampCache) (*BuildPlan, error) {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	plan := &BuildPlan{
+		Packages: []*Package{},
+		Platform: platform,
+		imports:  map[*Package][]*Package{},
+		stamps:   stamps,
+	}
+	// packages of a vendored project share an object, so they are one node
+	state := map[string]int{}
+	nodes := map[string]*Package{}
+	stale := map[string]bool{}
+	stack := []*Package{}
+	var visit func(pkg *Package) error
+	visit = func(pkg *Package) error {
+		key := pkg.ObjectPath
+		switch state[key] {
+		case visited:
+			return nil
+		case visiting:
+			return newImportCycleError(stack, pkg)
+		}
+		state[key] = visiting
+		nodes[key] = pkg
+		stack = append(stack, pkg)
+		imports := []*Package{}
+		for _, imp := range r.objectImports(pkg) {
+			if err := visit(imp); err != nil {
+				return err
+			}
+			if stale[imp.ObjectPath] {
+				imports = append(imports, nodes[imp.ObjectPath])
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[key] = visited
+		if len(imports) > 0 || newRunJob(pkg, r, platform, stamps).stale() {
+			stale[key] = true
+			plan.Packages = append(plan.Packages, pkg)
+			plan.imports[pkg] = imports
+		}
+		return nil
+	}
+	for _, pkg := range targets {
+		if err := visit(pkg); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+func newImportCycleError(stack []*Package, pkg *Package) error {
+	cycle := []string{}
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].ObjectPath == pkg.ObjectPath {
+			for _, p := range stack[i:] {
+				cycle = append(cycle, p.FullName)
+			}
+			break
+		}
+	}
+	return &ImportCycleError{Cycle: append(cycle, pkg.FullName)}
+}
+
+// objectImports returns the packages imported by the packages sharing pkg's
+// object, one package per object. The caller holds r.m.
+func (r *PackageRepository) objectImports(pkg *Package) []*Package {
+	pkgs := []*Package{pkg}
+	if pkg.InVendor {
+		pkgs = r.findByObjectPath(pkg.ObjectPath)
+	}
+	imports := []*Package{}
+	seen := map[string]bool{pkg.ObjectPath: true}
+	for _, p := range pkgs {
+		for _, name := range p.Imports {
+			imp := r.findByImportName(name)
+			if imp == nil || seen[imp.ObjectPath] {
+				continue
+			}
+			seen[imp.ObjectPath] = true
+			imports = append(imports, imp)
+		}
+	}
+	return imports
+}
@@ -0,0 +1,88 @@
+package rbgo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newMemoryPackage(repo *PackageRepository, name string, imports ...string) *Package {
+	pkg := NewPackage("/nonexistent/src", "/nonexistent/src/"+name)
+	pkg.Name = name
+	pkg.FullName = name
+	pkg.ObjectPath = "/nonexistent/pkg/" + name + ".a"
+	pkg.Imports = imports
+	repo.Put(pkg)
+	return pkg
+}
+
+func TestPackageRepository_BuildPlan(t *testing.T) {
+	repo := new(PackageRepository).Init()
+	a := newMemoryPackage(repo, "a", "b", "c")
+	b := newMemoryPackage(repo, "b", "c", "fmt")
+	c := newMemoryPackage(repo, "c")
+	plan, err := repo.BuildPlan([]*Package{a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := plan.Packages, []*Package{c, b, a}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := plan.Imports(a), []*Package{b, c}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := plan.Imports(c), []*Package{}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestPackageRepository_BuildPlan_Cycle(t *testing.T) {
+	repo := new(PackageRepository).Init()
+	a := newMemoryPackage(repo, "a", "b")
+	newMemoryPackage(repo, "b", "c")
+	newMemoryPackage(repo, "c", "b")
+	_, err := repo.BuildPlan([]*Package{a})
+	cycleErr, ok := err.(*ImportCycleError)
+	if !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a, e := cycleErr.Cycle, []string{"b", "c", "b"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := cycleErr.Error(), "import cycle not allowed: b -> c -> b"; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestPackageRepository_ReverseDepends(t *testing.T) {
+	repo := new(PackageRepository).Init()
+	a := newMemoryPackage(repo, "a", "b")
+	b := newMemoryPackage(repo, "b", "c")
+	c := newMemoryPackage(repo, "c")
+	d := newMemoryPackage(repo, "d", "a", "c")
+	newMemoryPackage(repo, "e")
+	repo.UpdateDepends()
+	closure := map[*Package]bool{}
+	for _, pkg := range repo.ReverseDepends([]*Package{b}) {
+		closure[pkg] = true
+	}
+	if a, e := closure, map[*Package]bool{a: true, b: true, d: true}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	plan, err := repo.BuildPlan(repo.ReverseDepends([]*Package{c}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := map[*Package]int{}
+	for i, pkg := range plan.Packages {
+		order[pkg] = i
+	}
+	if !(order[c] < order[b] && order[b] < order[a] && order[a] < order[d]) {
+		t.Errorf("not in dependency order: %v", plan.Packages)
+	}
+}
@@ -0,0 +1,94 @@
+package rbgo
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Platform is a GOOS/GOARCH target. The zero Platform is the default target,
+// GOOS and GOARCH of the environment.
+type Platform struct {
+	GOOS   string
+	GOARCH string
+}
+
+func DefaultPlatform() Platform {
+	return Platform{GOOS: buildOS, GOARCH: buildArch}
+}
+
+// ParsePlatform parses `GOOS/GOARCH`, e.g. `linux/arm64`.
+func ParsePlatform(s string) (Platform, error) {
+	pair := strings.Split(s, "/")
+	if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform `%s`, expect GOOS/GOARCH", s)
+	}
+	return Platform{GOOS: pair[0], GOARCH: pair[1]}, nil
+}
+
+func (p Platform) resolve() Platform {
+	if p == (Platform{}) {
+		return DefaultPlatform()
+	}
+	return p
+}
+
+// IsDefault reports whether p is the target of the packages' ObjectPath.
+func (p Platform) IsDefault() bool {
+	return p.resolve() == DefaultPlatform()
+}
+
+func (p Platform) String() string {
+	p = p.resolve()
+	return p.GOOS + "/" + p.GOARCH
+}
+
+// dirName is the directory of the platform's objects, `GOOS_GOARCH`.
+func (p Platform) dirName() string {
+	p = p.resolve()
+	return p.GOOS + "_" + p.GOARCH
+}
+
+// buildContext returns base, the default context if nil, targeting p.
+func (p Platform) buildContext(base *build.Context) *build.Context {
+	if base == nil {
+		base = DefaultBuildContext()
+	}
+	ctxt := *base
+	if p != (Platform{}) && (ctxt.GOOS != p.GOOS || ctxt.GOARCH != p.GOARCH) {
+		ctxt.GOOS, ctxt.GOARCH = p.GOOS, p.GOARCH
+		// the go command disables cgo when cross compiling unless it's set
+		if os.Getenv("CGO_ENABLED") == "" {
+			ctxt.CgoEnabled = false
+		}
+	}
+	return &ctxt
+}
+
+// PlatformObjectPath returns where the package is built to for the platform.
+// Archives go to `pkg/GOOS_GOARCH` instead of PackageDirName, and commands to
+// a `GOOS_GOARCH` directory next to the default binary.
+func (p *Package) PlatformObjectPath(platform Platform) string {
+	if platform.IsDefault() {
+		return p.ObjectPath
+	}
+	dir := platform.dirName()
+	if p.IsCommand {
+		name := strings.TrimSuffix(filepath.Base(p.ObjectPath), ".exe")
+		if platform.GOOS == "windows" {
+			name += ".exe"
+		}
+		return filepath.Join(filepath.Dir(p.ObjectPath), dir, name)
+	}
+	sep := string(filepath.Separator)
+	archives := PackageDirName + sep
+	if strings.HasPrefix(p.ObjectPath, archives) {
+		return filepath.Join("pkg", dir, p.ObjectPath[len(archives):])
+	}
+	if i := strings.Index(p.ObjectPath, sep+archives); i >= 0 {
+		return filepath.Join(p.ObjectPath[:i], "pkg", dir, p.ObjectPath[i+len(sep+archives):])
+	}
+	return filepath.Join(filepath.Dir(p.ObjectPath), dir, filepath.Base(p.ObjectPath))
+}
@@ -0,0 +1,90 @@
+package rbgo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	p, err := ParsePlatform("js/wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := p, (Platform{GOOS: "js", GOARCH: "wasm"}); a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	for _, s := range []string{"linux", "linux/", "/amd64", "linux/amd64/v2"} {
+		if _, err := ParsePlatform(s); err == nil {
+			t.Errorf("no error: `%s`", s)
+		}
+	}
+	if a, e := (Platform{}).String(), DefaultPlatform().String(); a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestPackage_PlatformObjectPath(t *testing.T) {
+	windows := Platform{GOOS: "windows", GOARCH: "amd64"}
+	if windows.IsDefault() {
+		t.Skip("default platform")
+	}
+	pkg := &Package{ObjectPath: filepath.Join("/ws", PackageDirName, "example.com", "hello.a")}
+	if a, e := pkg.PlatformObjectPath(windows), filepath.Join("/ws", "pkg", "windows_amd64", "example.com", "hello.a"); a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := pkg.PlatformObjectPath(Platform{}), pkg.ObjectPath; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	cmd := &Package{ObjectPath: filepath.Join("/ws", "bin", "server"), IsCommand: true}
+	if a, e := cmd.PlatformObjectPath(windows), filepath.Join("/ws", "bin", "windows_amd64", "server.exe"); a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestPackageRepository_BuildMatrix(t *testing.T) {
+	windows := Platform{GOOS: "windows", GOARCH: "amd64"}
+	if windows.IsDefault() {
+		t.Skip("default platform")
+	}
+	ws := newTempModule(t, map[string]string{
+		"hello/hello.go":         "package hello\n\nfunc Hello() string { return \"hello \" + OS }\n",
+		"hello/hello_other.go":   "//go:build !windows\n\npackage hello\n\nconst OS = \"other\"\n",
+		"hello/hello_windows.go": "package hello\n\nconst OS = \"windows\"\n",
+	})
+	hello := ws.Package.FindByPath(filepath.Join(ws.root, "hello"))
+	platforms := []Platform{{}, windows}
+	report, err := ws.Package.BuildMatrix([]*Package{hello}, platforms, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || len(report.Built()) != 2 {
+		t.Fatalf("build failed: %v %v", report, report.Failed())
+	}
+	if a, e := report.Platforms[1].Results[0].Task.ObjectPath, filepath.Join(ws.root, "pkg", "windows_amd64", "example.com", "temp", "hello.a"); a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	// the windows source is excluded from the default build only
+	source := filepath.Join(ws.root, "hello", "hello_windows.go")
+	if err := ioutil.WriteFile(source, []byte("package hello\n\nconst OS = \"win\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plans, err := ws.Package.BuildPlans([]*Package{hello}, platforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := len(plans[0].Packages), 0; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := len(plans[1].Packages), 1; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
@@ -0,0 +1,123 @@
+package rbgo
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const DefaultGracePeriod = 5 * time.Second
+
+// Process supervises a running command binary.
+type Process struct {
+	Path        string
+	Args        []string
+	Dir         string
+	GracePeriod time.Duration
+	Stdout      io.Writer
+	Stderr      io.Writer
+	cmd         *exec.Cmd
+	done        chan struct{}
+	m           sync.Mutex
+}
+
+func NewProcess(path string, args ...string) *Process {
+	return &Process{
+		Path:        path,
+		Args:        args,
+		GracePeriod: DefaultGracePeriod,
+		Stdout:      os.Stdout,
+		Stderr:      os.Stderr,
+	}
+}
+
+func (p *Process) Start() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.running() {
+		return nil
+	}
+	return p.start()
+}
+
+func (p *Process) start() error {
+	cmd := exec.Command(p.Path, p.Args...)
+	cmd.Dir = p.Dir
+	cmd.Stdout = p.Stdout
+	cmd.Stderr = p.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	p.cmd = cmd
+	p.done = done
+	return nil
+}
+
+// Stop sends SIGTERM and kills the process if it has not exited within the
+// grace period.
+func (p *Process) Stop() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.stop()
+}
+
+func (p *Process) stop() error {
+	if !p.running() {
+		return nil
+	}
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		// e.g. Windows can't deliver SIGTERM
+		return p.kill()
+	}
+	select {
+	case <-p.done:
+		return nil
+	case <-time.After(p.GracePeriod):
+		return p.kill()
+	}
+}
+
+func (p *Process) kill() error {
+	if err := p.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	<-p.done
+	return nil
+}
+
+// Restart stops the running process and starts it again with the current
+// binary.
+func (p *Process) Restart() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if err := p.stop(); err != nil {
+		return err
+	}
+	return p.start()
+}
+
+func (p *Process) Running() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.running()
+}
+
+func (p *Process) running() bool {
+	if p.cmd == nil {
+		return false
+	}
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,73 @@
+package rbgo
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess isn't a real test, it's the child process the other
+// tests supervise.
+func TestHelperProcess(t *testing.T) {
+	switch os.Getenv("RBGO_HELPER_PROCESS") {
+	case "":
+		return
+	case "ignore-term":
+		signal.Ignore(syscall.SIGTERM)
+	}
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+func helperProcess(mode string) *Process {
+	os.Setenv("RBGO_HELPER_PROCESS", mode)
+	return NewProcess(os.Args[0], "-test.run=TestHelperProcess")
+}
+
+func TestProcess_Restart(t *testing.T) {
+	p := helperProcess("sleep")
+	defer os.Unsetenv("RBGO_HELPER_PROCESS")
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+	if a, e := p.Running(), true; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	pid := p.cmd.Process.Pid
+	if err := p.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if a, e := p.Running(), true; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if p.cmd.Process.Pid == pid {
+		t.Error("process not restarted")
+	}
+}
+
+func TestProcess_Stop_GracePeriod(t *testing.T) {
+	p := helperProcess("ignore-term")
+	defer os.Unsetenv("RBGO_HELPER_PROCESS")
+	p.GracePeriod = 100 * time.Millisecond
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// give the child time to ignore SIGTERM
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < p.GracePeriod {
+		t.Errorf("killed before grace period: %v", elapsed)
+	}
+	if a, e := p.Running(), false; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
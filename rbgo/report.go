@@ -0,0 +1,117 @@
+package rbgo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BuildReport is the outcome of a one-shot build of some packages.
+type BuildReport struct {
+	// Platform is the target the report is of, zero for the whole build.
+	Platform Platform
+	// Results are in build order, platform after platform.
+	Results []*BuildResult
+	// Fresh are the targets which were up to date, once per platform.
+	Fresh []*Package
+	// Missing are the targets importing packages which weren't found.
+	Missing  []*Package
+	Duration time.Duration
+	// Platforms are the reports of every platform built.
+	Platforms []*BuildReport
+}
+
+// Build builds the stale packages among targets and their imports once,
+// waiting for every build to finish.
+func (r *PackageRepository) Build(targets []*Package, workers int) (*BuildReport, error) {
+	return r.BuildMatrix(targets, nil, workers)
+}
+
+// BuildMatrix builds like Build for every platform at once, the default
+// platform if none.
+func (r *PackageRepository) BuildMatrix(targets []*Package, platforms []Platform, workers int) (*BuildReport, error) {
+	start := time.Now()
+	plans, err := r.BuildPlans(targets, platforms)
+	if err != nil {
+		return nil, err
+	}
+	scheduler := Scheduler{Package: r, Workers: workers}
+	type target struct {
+		pkg      *Package
+		platform Platform
+	}
+	results := map[target]*BuildResult{}
+	for _, result := range scheduler.RunPlans(context.Background(), plans) {
+		results[target{result.Task.Package, result.Task.Platform}] = result
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	report := &BuildReport{
+		Results:   []*BuildResult{},
+		Fresh:     []*Package{},
+		Missing:   []*Package{},
+		Platforms: make([]*BuildReport, 0, len(plans)),
+	}
+	for _, plan := range plans {
+		p := &BuildReport{
+			Platform: plan.Platform,
+			Results:  make([]*BuildResult, 0, len(plan.Packages)),
+			Fresh:    []*Package{},
+			Missing:  []*Package{},
+		}
+		planned := map[string]bool{}
+		for _, pkg := range plan.Packages {
+			planned[pkg.ObjectPath] = true
+			p.Results = append(p.Results, results[target{pkg, plan.Platform}])
+		}
+		for _, pkg := range targets {
+			if len(pkg.MissingImports) > 0 {
+				p.Missing = append(p.Missing, pkg)
+			} else if !planned[pkg.ObjectPath] {
+				p.Fresh = append(p.Fresh, pkg)
+			}
+		}
+		report.Results = append(report.Results, p.Results...)
+		report.Fresh = append(report.Fresh, p.Fresh...)
+		// the imports are the same for every platform
+		report.Missing = p.Missing
+		report.Platforms = append(report.Platforms, p)
+	}
+	report.Duration = time.Since(start)
+	for _, p := range report.Platforms {
+		p.Duration = report.Duration
+	}
+	return report, nil
+}
+
+// Built returns the results of the successful builds.
+func (b *BuildReport) Built() []*BuildResult {
+	built := []*BuildResult{}
+	for _, r := range b.Results {
+		if r.Built {
+			built = append(built, r)
+		}
+	}
+	return built
+}
+
+// Failed returns the results of the failed builds.
+func (b *BuildReport) Failed() []*BuildResult {
+	failed := []*BuildResult{}
+	for _, r := range b.Results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// OK reports whether every build succeeded and no import is missing.
+func (b *BuildReport) OK() bool {
+	return len(b.Failed()) == 0 && len(b.Missing) == 0
+}
+
+func (b *BuildReport) String() string {
+	return fmt.Sprintf("%d built, %d failed, %d up to date, %d missing imports (%v)",
+		len(b.Built()), len(b.Failed()), len(b.Fresh), len(b.Missing), b.Duration.Round(time.Millisecond))
+}
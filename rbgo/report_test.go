@@ -0,0 +1,66 @@
+package rbgo
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPackageRepository_Build(t *testing.T) {
+	repo, pkgs := newExampleRepository()
+	report, err := repo.Build(pkgs[:1], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range report.Results {
+		defer os.Remove(r.Task.ObjectPath)
+	}
+	if !report.OK() {
+		t.Fatalf("build failed: %v", report)
+	}
+	if a, e := len(report.Built()), 3; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := report.Results[2].Task.Package, pkgs[0]; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+
+	report, err = repo.Build(pkgs[:1], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := len(report.Results), 0; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := len(report.Fresh), 1; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestPackageRepository_Build_Missing(t *testing.T) {
+	repo, pkgs := newExampleRepository()
+	pkgs[2].MissingImports = []string{"github.com/kai-zoa/hakone"}
+	report, err := repo.Build(pkgs, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range report.Results {
+		if r.Built {
+			os.Remove(r.Task.ObjectPath)
+		}
+	}
+	if report.OK() {
+		t.Errorf("expect failure: %v", report)
+	}
+	if a, e := len(report.Failed()), 3; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := len(report.Missing), 1; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
@@ -0,0 +1,87 @@
+package rbgo
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type TestResult struct {
+	Task     *Task
+	Passed   bool
+	Output   string
+	Duration time.Duration
+	Err      error
+}
+
+func (t *Task) testCommand(ctx context.Context) *exec.Cmd {
+	source := normalizePath(relativePath(t.Package.WorkDir, t.Package.WatchPath))
+	arguments := []string{"test"}
+	// the tests see the files the build does
+	if o := t.Package.Options; o != nil {
+		if tags := o.tags(); len(tags) > 0 {
+			arguments = append(arguments, "-tags="+strings.Join(tags, ","))
+		}
+		if o.race() {
+			arguments = append(arguments, "-race")
+		}
+	}
+	command := exec.CommandContext(ctx, "go", append(arguments, source)...)
+	command.Dir = t.Package.WorkDir
+	command.Env = t.environ()
+	return command
+}
+
+// Test runs `go test` for the package. A failing test isn't an error, Err is
+// set only when the tests couldn't be run at all.
+func (t *Task) Test() *TestResult {
+	return t.TestContext(context.Background())
+}
+
+// TestContext tests like Test, killing `go test` when ctx is done.
+func (t *Task) TestContext(ctx context.Context) *TestResult {
+	unlock := t.repo.rlock()
+	command := t.testCommand(ctx)
+	unlock()
+	out := new(bytes.Buffer)
+	command.Stdout = out
+	command.Stderr = out
+	start := time.Now()
+	err := command.Run()
+	result := &TestResult{
+		Task:     t,
+		Passed:   err == nil,
+		Output:   out.String(),
+		Duration: time.Since(start),
+	}
+	if ctx.Err() != nil {
+		result.Passed = false
+		result.Err = ctx.Err()
+	} else if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		result.Err = err
+	}
+	return result
+}
+
+// TestTargets returns the packages whose tests are affected by a change to
+// pkgs, the reverse dependents too if referrers is set. Packages without test
+// files and vendored packages are skipped.
+func (r *PackageRepository) TestTargets(pkgs []*Package, referrers bool) []*Package {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	if referrers {
+		pkgs = r.reverseDepends(pkgs)
+	}
+	targets := []*Package{}
+	seen := map[*Package]bool{}
+	for _, pkg := range pkgs {
+		if seen[pkg] || pkg.InVendor || len(pkg.TestFiles) == 0 {
+			continue
+		}
+		seen[pkg] = true
+		targets = append(targets, pkg)
+	}
+	return targets
+}
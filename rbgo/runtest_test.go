@@ -0,0 +1,52 @@
+package rbgo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTask_Test(t *testing.T) {
+	w, err := NewWorkspace("../example/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.CacheDir = ""
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	hello := w.Package.FindByImportName("github.com/kai-zoa/example/hello")
+	if hello == nil {
+		t.Fatal("pkg not found")
+	}
+	if a, e := hello.TestFiles, []string{"../example/mod/hello/hello_test.go"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	r := newJob(hello, w.Package).Test()
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if !r.Passed {
+		t.Errorf("test failed:\n%s", r.Output)
+	}
+}
+
+func TestPackageRepository_TestTargets(t *testing.T) {
+	w, err := NewWorkspace("../example/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	hello := w.Package.FindByImportName("github.com/kai-zoa/example/hello")
+	greeting := w.Package.FindByImportName("github.com/kai-zoa/example/greeting")
+	if a, e := w.Package.TestTargets([]*Package{greeting}, false), []*Package{}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := w.Package.TestTargets([]*Package{hello}, true), []*Package{hello}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
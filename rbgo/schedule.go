@@ -0,0 +1,143 @@
+package rbgo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+type BuildResult struct {
+	Task     *Task
+	Built    bool
+	Err      error
+	Duration time.Duration
+}
+
+// Scheduler runs a BuildPlan concurrently with a bounded number of workers.
+// A package is built only after the packages it imports in the plan have
+// finished.
+type Scheduler struct {
+	Package *PackageRepository
+	Workers int
+	// Log receives the output of the builds, os.Stdout if nil.
+	Log io.Writer
+	// Started and Finished are called from the workers around every build.
+	Started  func(task *Task)
+	Finished func(result *BuildResult)
+}
+
+type scheduleNode struct {
+	task   *Task
+	done   chan struct{}
+	result *BuildResult
+}
+
+func (s *Scheduler) workers() int {
+	if s.Workers > 0 {
+		return s.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Run builds the packages of the plan, returning the results in the order
+// the builds finished.
+func (s *Scheduler) Run(plan *BuildPlan) []*BuildResult {
+	return s.RunContext(context.Background(), plan)
+}
+
+// RunContext runs like Run. When ctx is done the running builds are killed
+// and the rest fail with ctx.Err().
+func (s *Scheduler) RunContext(ctx context.Context, plan *BuildPlan) []*BuildResult {
+	return s.RunPlans(ctx, []*BuildPlan{plan})
+}
+
+// RunPlans runs the plans of several platforms at once, sharing the workers.
+func (s *Scheduler) RunPlans(ctx context.Context, plans []*BuildPlan) []*BuildResult {
+	results := []*BuildResult{}
+	m := new(sync.Mutex)
+	sem := make(chan struct{}, s.workers())
+	wg := new(sync.WaitGroup)
+	for _, plan := range plans {
+		s.runPlan(ctx, plan, sem, wg, func(r *BuildResult) {
+			m.Lock()
+			results = append(results, r)
+			m.Unlock()
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// runPlan starts a goroutine per package of the plan, waiting for the
+// packages it imports and a worker slot of sem before building.
+func (s *Scheduler) runPlan(ctx context.Context, plan *BuildPlan, sem chan struct{}, wg *sync.WaitGroup, done func(*BuildResult)) {
+	nodes := make(map[*Package]*scheduleNode, len(plan.Packages))
+	unlock := s.Package.rlock()
+	for _, pkg := range plan.Packages {
+		task := newRunJob(pkg, s.Package, plan.Platform, plan.stamps)
+		task.Log = s.Log
+		nodes[pkg] = &scheduleNode{
+			task: task,
+			done: make(chan struct{}),
+		}
+	}
+	unlock()
+
+	for _, pkg := range plan.Packages {
+		wg.Add(1)
+		go func(pkg *Package, n *scheduleNode) {
+			defer wg.Done()
+			defer close(n.done)
+			n.result = &BuildResult{Task: n.task}
+			for _, imp := range plan.Imports(pkg) {
+				dep := nodes[imp]
+				<-dep.done
+				if ctx.Err() != nil {
+					n.result.Err = ctx.Err()
+				} else if dep.result.Err != nil {
+					n.result.Err = fmt.Errorf("Dependency failed: `%s`", dep.task.PackageName)
+				}
+			}
+			if n.result.Err == nil {
+				n.result.Err = s.acquire(ctx, sem)
+			}
+			if n.result.Err == nil {
+				if s.Started != nil {
+					s.Started(n.task)
+				}
+				start := time.Now()
+				n.result.Err = s.build(ctx, n.task)
+				n.result.Built = n.result.Err == nil
+				n.result.Duration = time.Since(start)
+				<-sem
+			}
+			if s.Finished != nil {
+				s.Finished(n.result)
+			}
+			done(n.result)
+		}(pkg, nodes[pkg])
+	}
+}
+
+// acquire takes a worker slot unless ctx is done first.
+func (s *Scheduler) acquire(ctx context.Context, sem chan struct{}) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case sem <- struct{}{}:
+		return nil
+	}
+}
+
+func (s *Scheduler) build(ctx context.Context, task *Task) error {
+	unlock := s.Package.rlock()
+	missing := task.Package.MissingImports
+	unlock()
+	if len(missing) > 0 {
+		return fmt.Errorf("Package not found '%s'", missing[0])
+	}
+	return task.BuildContext(ctx)
+}
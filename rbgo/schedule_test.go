@@ -0,0 +1,82 @@
+package rbgo
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func newExampleRepository() (*PackageRepository, []*Package) {
+	finder := PackageRootFinder([]*regexp.Regexp{})
+	finder = append(finder, regexp.MustCompile("github.com/[a-zA-Z0-9_-]+/[a-zA-Z0-9_-]+"))
+	sourceRoot := "../example/src"
+	repo := new(PackageRepository).Init()
+	pkg1 := NewPackage(sourceRoot, "../example/src/hoge/piyo")
+	pkg2 := NewPackage(sourceRoot, "../example/src/vendor/github.com/kai-zoa/geeyoko")
+	pkg3 := NewPackage(sourceRoot, "../example/src/vendor/github.com/kai-zoa/yokohama")
+	pkgAll := []*Package{pkg1, pkg2, pkg3}
+	for _, pkg := range pkgAll {
+		pkg.Scan(finder)
+		repo.Put(pkg)
+	}
+	repo.UpdateDepends()
+	return repo, pkgAll
+}
+
+func TestScheduler_Run(t *testing.T) {
+	repo, pkgs := newExampleRepository()
+	scheduler := Scheduler{Package: repo, Workers: 2}
+	plan, err := repo.BuildPlan(pkgs[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := scheduler.Run(plan)
+	for _, r := range results {
+		defer os.Remove(r.Task.ObjectPath)
+	}
+	order := []string{}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if !r.Built {
+			t.Errorf("not built: `%s`", r.Task.PackageName)
+		}
+		order = append(order, r.Task.PackageName)
+	}
+	expect := []string{"github.com/kai-zoa/yokohama", "github.com/kai-zoa/geeyoko", "hoge/piyo"}
+	if a, e := order, expect; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	plan, err = repo.BuildPlan(pkgs[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := len(plan.Packages), 0; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestScheduler_Run_DependencyFailed(t *testing.T) {
+	repo, pkgs := newExampleRepository()
+	pkgs[2].MissingImports = []string{"github.com/kai-zoa/hakone"}
+	scheduler := Scheduler{Package: repo}
+	plan, err := repo.BuildPlan(pkgs[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := scheduler.Run(plan)
+	if a, e := len(results), 3; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	for _, r := range results {
+		if r.Err == nil || r.Built {
+			t.Errorf("unexpected build: `%s`", r.Task.PackageName)
+			os.Remove(r.Task.ObjectPath)
+		}
+	}
+}
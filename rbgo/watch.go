@@ -1,19 +1,23 @@
 package rbgo
 
 import (
-	"github.com/howeyc/fsnotify"
+	"context"
 	"fmt"
+	"io"
 	"path/filepath"
 	"os"
+	"os/exec"
+	"runtime"
 	"time"
-	"sync"
 	"strings"
+	"sync"
 )
 
 const (
 	EventFound = EventName("Found")
 	EventUpdate = EventName("Update")
 	EventDelete = EventName("Delete")
+	EventTestUpdate = EventName("TestUpdate")
 )
 
 type EventName string
@@ -23,75 +27,199 @@ type Event struct {
 	Pacakge *Package
 }
 
-type EventBuffer struct {
-	b []*Event
-	m *sync.Mutex
-	t time.Time
+type Watcher struct {
+	Workspace     *Workspace
+	Workers       int
+	Supervise     bool
+	Test          bool
+	TestReferrers bool
+	GracePeriod   time.Duration
+	Debounce      time.Duration
+	MaxWait       time.Duration
+	// Backend is the FileWatcher backend, fsnotify if empty.
+	Backend       string
+	PollInterval  time.Duration
+	Args          map[string][]string
+	// Select restricts the packages built, tested and run to the ones it
+	// accepts, every package if nil. Their imports are built as needed.
+	Select        func(pkg *Package) bool
+	// Observer is notified of the events and builds, a ConsoleObserver
+	// printing to stdout if nil.
+	Observer      Observer
+	// Log receives the output of the builds, hooks and binaries, os.Stdout
+	// if nil.
+	Log           io.Writer
+	factory       *TaskFactory
+	processes     map[string]*Process
+	observer      Observer
 }
 
-func (e *EventBuffer) init() {
-	e.b = make([]*Event, 0)
-	e.m = new(sync.Mutex)
-	e.t = time.Now()
+// applyConfig fills the settings left unset from the workspace config.
+func (w *Watcher) applyConfig() {
+	if w.Args == nil {
+		w.Args = map[string][]string{}
+	}
+	if c := w.Workspace.Config; c != nil {
+		if w.Debounce == 0 {
+			w.Debounce = c.DebounceDuration()
+		}
+		if w.MaxWait == 0 {
+			w.MaxWait = c.MaxWaitDuration()
+		}
+		if w.Backend == "" {
+			w.Backend = c.Watcher.Backend
+		}
+		if w.PollInterval == 0 {
+			w.PollInterval = c.PollIntervalDuration()
+		}
+		for target, args := range c.TargetArgs() {
+			if _, found := w.Args[target]; !found {
+				w.Args[target] = args
+			}
+		}
+	}
+	if w.Debounce == 0 {
+		w.Debounce = DefaultDebounce
+	}
+	if w.MaxWait == 0 {
+		w.MaxWait = DefaultMaxWait
+	}
+	if w.Log == nil {
+		w.Log = os.Stdout
+	}
+	if w.Observer == nil {
+		w.Observer = &ConsoleObserver{W: os.Stdout}
+	}
+	w.observer = &syncObserver{o: w.Observer}
 }
 
-func (e *EventBuffer) add(event *Event) {
-	e.m.Lock()
-	defer e.m.Unlock()
-	e.b = append(e.b, event)
-	e.t = time.Now()
+// selected returns the packages accepted by Select.
+func (w *Watcher) selected(pkgs []*Package) []*Package {
+	if w.Select == nil {
+		return pkgs
+	}
+	defer w.Workspace.Package.rlock()()
+	selected := []*Package{}
+	for _, pkg := range pkgs {
+		if w.Select(pkg) {
+			selected = append(selected, pkg)
+		}
+	}
+	return selected
 }
 
-func (e *EventBuffer) fetch() (events []*Event) {
-	e.m.Lock()
-	defer e.m.Unlock()
-	elapse := (time.Now().Sub(e.t) / time.Millisecond)
-	if len(e.b) > 0 && elapse > 1000 {
-		var prev *Event
-		events = make([]*Event, 0, len(e.b))
-		for _, ev := range e.b {
-			if prev != nil &&
-			prev.Name == ev.Name &&
-			prev.Pacakge.WatchPath == ev.Pacakge.WatchPath {
-				continue
-			}
-			events = append(events ,ev)
-			prev = ev
+// runHooks runs the shell commands in the workspace root, stopping at the
+// first failure.
+func (w *Watcher) runHooks(ctx context.Context, commands []string) error {
+	for _, c := range commands {
+		w.observer.OnHook(c)
+		command := exec.CommandContext(ctx, "sh", "-c", c)
+		if runtime.GOOS == "windows" {
+			command = exec.CommandContext(ctx, "cmd", "/C", c)
+		}
+		command.Dir = w.Workspace.root
+		command.Stdout = w.Log
+		command.Stderr = os.Stderr
+		if err := command.Run(); err != nil {
+			return fmt.Errorf("hook `%s`: %v", c, err)
 		}
-		e.b = make([]*Event, 0, len(e.b))
 	}
-	return events
+	return nil
 }
 
-type Watcher struct {
-	Workspace *Workspace
-	factory   *TaskFactory
+// supervise starts the command's binary, restarting it when it was rebuilt.
+func (w *Watcher) supervise(pkg *Package, built bool) error {
+	if w.processes == nil {
+		w.processes = map[string]*Process{}
+	}
+	p, found := w.processes[pkg.FullName]
+	if !found {
+		p = NewProcess(pkg.ObjectPath, w.Args[pkg.FullName]...)
+		p.Dir = pkg.WorkDir
+		p.Stdout = w.Log
+		if w.GracePeriod > 0 {
+			p.GracePeriod = w.GracePeriod
+		}
+		w.processes[pkg.FullName] = p
+	}
+	if built || !found {
+		w.observer.OnRestart(pkg)
+		return p.Restart()
+	}
+	return nil
 }
 
-func (w *Watcher) Watch() error {
-	watcher, err := fsnotify.NewWatcher()
+func (w *Watcher) stopProcesses() {
+	for _, p := range w.processes {
+		p.Stop()
+	}
+}
+
+// Watch builds the workspace and rebuilds it on every change until ctx is
+// done. Then it kills the running builds, stops the supervised binaries and
+// returns nil.
+func (w *Watcher) Watch(ctx context.Context) error {
+	w.applyConfig()
+	watcher, err := NewFileWatcher(w.Backend, w.PollInterval)
 	if err != nil {
 		return err
 	}
-	defer watcher.Close()
-
-	buf := EventBuffer{}
-	buf.init()
+	w.observer.OnScan(len(w.Workspace.Package.All()))
+	for _, err := range w.Workspace.ScanErrors {
+		w.observer.OnError(err)
+	}
+	debouncer := NewDebouncer(w.Debounce, w.MaxWait)
+	defer debouncer.Stop()
+	// the limit is reported again whenever a found package adds polled directories
+	var limitM sync.Mutex
+	reported := 0
+	reportLimit := func() {
+		f, ok := watcher.(*FallbackWatcher)
+		if !ok {
+			return
+		}
+		limitM.Lock()
+		defer limitM.Unlock()
+		if limit := f.LimitError(); limit != nil && limit.Requested-limit.Watched > reported {
+			reported = limit.Requested - limit.Watched
+			w.observer.OnError(limit)
+		}
+	}
+	// the listener may be adding a directory
+	ctx, cancel := context.WithCancel(ctx)
+	listening := make(chan struct{})
+	defer func() {
+		cancel()
+		<-listening
+		watcher.Close()
+	}()
 	go func() {
+		defer close(listening)
 		for {
 			select {
-			case fsev := <-watcher.Event:
-				events := handleFSNotify(w.Workspace, fsev)
+			case fsev, ok := <-watcher.Events():
+				if !ok {
+					return
+				}
+				events := handleFileEvent(w.Workspace, fsev.Path)
 				for _, e := range events {
 					if e.Name == EventFound {
-						watcher.Watch(e.Pacakge.WatchPath)
+						w.observer.OnEvent(e)
+						watcher.Add(e.Pacakge.WatchPath)
+						reportLimit()
 					} else {
-						buf.add(e)
+						debouncer.Add(e)
 					}
 				}
 
-			case event := <-watcher.Error:
-				fmt.Println("error " + event.Error())
+			case event, ok := <-watcher.Errors():
+				if !ok {
+					return
+				}
+				w.observer.OnError(event)
+
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -103,130 +231,361 @@ func (w *Watcher) Watch() error {
 			return nil
 		}
 		n += 1
-		return watcher.Watch(path)
+		return watcher.Add(path)
 	})
-	fmt.Printf("Watch %d directories\n", n)
+	// the root of a go.work is in none of its modules
+	if err == nil && w.Workspace.ModuleMode() && w.Workspace.FindModule(w.Workspace.root) == nil {
+		n += 1
+		err = watcher.Add(w.Workspace.root)
+	}
+	w.observer.OnWatch(n)
 	if err != nil {
 		return err
 	}
+	reportLimit()
 
-	factory := TaskFactory{Package: w.Workspace.Package}
-	runTask := func(pkg *Package) {
-		task, err := factory.New(pkg.WatchPath)
-		if err != nil {
-			fmt.Printf("Error: %s\n", err)
-		}
-		if err := build(task); err != nil {
-			fmt.Printf("Error: %s\n", err)
-		}
-	}
+	defer w.stopProcesses()
 
 	// Build All
-	fmt.Println("--- First Build Start")
-	all := w.Workspace.Package.All()
-	packages := make(map[string]*Package, len(all))
-	for _, pkg := range all {
-		packages[pkg.ObjectPath] = pkg
-	}
-	for _, pkg := range packages {
-		runTask(pkg)
-	}
+	fmt.Fprintln(w.Log, "--- First Build Start")
+	w.runTasks(ctx, w.selected(w.Workspace.Package.All()))
 
 	// Watch iNotify Events
-	fmt.Println("--- Watch Start")
+	fmt.Fprintln(w.Log, "--- Watch Start")
+	var running *buildRun
+	targets, tests := []*Package{}, []*Package{}
 	for {
-		if events := buf.fetch(); events != nil {
-			for _, e := range events {
-				fmt.Printf("%s: %s\n", e.Name, e.Pacakge.WatchPath)
-				if e.Name == EventUpdate {
-					runTask(e.Pacakge)
+		var done chan struct{}
+		if running != nil {
+			done = running.done
+		}
+		ready := false
+		select {
+		case <-ctx.Done():
+			if running != nil {
+				<-running.done
+			}
+			return nil
+		case <-done:
+			// the pending events wait for their quiet period
+			running = nil
+		case <-debouncer.Ready():
+			ready = true
+		}
+		updated := []*Package{}
+		if ready {
+			unlock := w.Workspace.Package.rlock()
+			for _, e := range debouncer.Fetch() {
+				w.observer.OnEvent(e)
+				switch e.Name {
+				case EventUpdate:
+					updated = append(updated, e.Pacakge)
+				case EventTestUpdate:
+					tests = append(tests, e.Pacakge)
 				}
 			}
+			unlock()
+		}
+		targets = append(targets, updated...)
+		if running != nil && running.affected(w.Workspace.Package.ReverseDepends(updated)) {
+			// the running build is obsolete, build again with its targets
+			fmt.Fprintln(w.Log, "--- Build Restart")
+			running.cancel()
+			<-running.done
+			targets = append(running.targets, targets...)
+			tests = append(running.tests, tests...)
+			running = nil
+		}
+		if running == nil && (len(targets) > 0 || len(tests) > 0) {
+			running = w.startBuild(ctx, targets, tests)
+			targets, tests = []*Package{}, []*Package{}
 		}
-		time.Sleep(time.Second)
 	}
-	return nil
 }
 
-func build(task *Task) error {
-	for {
-		if dep, err := task.FindDepends(); err != nil {
-			return err
-		} else if dep != nil {
-			if err := build(dep); err != nil {
-				return err
+// buildRun is a rebuild running in the background.
+type buildRun struct {
+	targets []*Package
+	tests   []*Package
+	builds  map[*Package]bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// affected reports whether any of pkgs is rebuilt by the run.
+func (r *buildRun) affected(pkgs []*Package) bool {
+	for _, pkg := range pkgs {
+		if r.builds[pkg] {
+			return true
+		}
+	}
+	return false
+}
+
+// startBuild rebuilds the updated packages and their reverse dependents, and
+// runs the affected tests, until the returned run is canceled.
+func (w *Watcher) startBuild(ctx context.Context, targets, tests []*Package) *buildRun {
+	ctx, cancel := context.WithCancel(ctx)
+	builds := []*Package{}
+	if len(targets) > 0 {
+		builds = w.selected(w.Workspace.Package.ReverseDepends(targets))
+	}
+	r := &buildRun{
+		targets: targets,
+		tests:   tests,
+		builds:  make(map[*Package]bool, len(builds)),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	for _, pkg := range builds {
+		r.builds[pkg] = true
+	}
+	go func() {
+		defer close(r.done)
+		defer cancel()
+		results := map[*Package]*BuildResult{}
+		if len(builds) > 0 {
+			results = w.runTasks(ctx, builds)
+		}
+		if w.Test && ctx.Err() == nil {
+			w.runTests(ctx, targets, tests, results)
+		}
+	}()
+	return r
+}
+
+// runTasks builds the stale targets for every platform of the workspace. It
+// returns the results of the default platform, the binaries run and tested
+// here.
+func (w *Watcher) runTasks(ctx context.Context, targets []*Package) map[*Package]*BuildResult {
+	plans, err := w.Workspace.Package.BuildPlans(targets, w.Workspace.Platforms)
+	if err != nil {
+		w.observer.OnError(err)
+		return nil
+	}
+	hooks := HooksConfig{}
+	if w.Workspace.Config != nil {
+		hooks = w.Workspace.Config.Hooks
+	}
+	stale, native := false, false
+	for _, plan := range plans {
+		stale = stale || len(plan.Packages) > 0
+		native = native || plan.Platform.IsDefault()
+	}
+	if stale {
+		if err := w.runHooks(ctx, hooks.PreBuild); err != nil {
+			if ctx.Err() == nil {
+				w.observer.OnError(err)
 			}
-		} else {
-			break
+			return nil
 		}
 	}
-	updated := false
-	if fi, err := os.Stat(task.ObjectPath); err != nil {
-		updated = true
-	} else if (fi.ModTime().Before(task.Package.ModTime)) {
-		updated = true
+	scheduler := Scheduler{
+		Package:  w.Workspace.Package,
+		Workers:  w.Workers,
+		Log:      w.Log,
+		Started:  w.observer.OnBuildStart,
+		Finished: w.observer.OnBuildResult,
 	}
-	if updated {
-		//fmt.Printf("Build: %s\n", task.ObjectPath)
-		if err := task.Build(); err != nil {
-			return err
+	results := map[*Package]*BuildResult{}
+	for _, r := range scheduler.RunPlans(ctx, plans) {
+		if r.Task.Platform.IsDefault() {
+			results[r.Task.Package] = r
 		}
 	}
-	return nil
+	if ctx.Err() != nil {
+		return results
+	}
+	if stale {
+		if err := w.runHooks(ctx, hooks.PostBuild); err != nil {
+			w.observer.OnError(err)
+		}
+	}
+	// binaries of the other platforms may not run here
+	if !w.Supervise || !native {
+		return results
+	}
+	// the packages may be rescanned while restarting, supervise copies
+	unlock := w.Workspace.Package.rlock()
+	commands, built := []*Package{}, []bool{}
+	for _, pkg := range targets {
+		if !pkg.IsCommand {
+			continue
+		}
+		b := false
+		if r, found := results[pkg]; found {
+			if r.Err != nil {
+				// keep the previous process running
+				continue
+			}
+			b = r.Built
+		}
+		command := *pkg
+		commands = append(commands, &command)
+		built = append(built, b)
+	}
+	unlock()
+	for i, pkg := range commands {
+		if err := w.supervise(pkg, built[i]); err != nil {
+			w.observer.OnError(err)
+		}
+	}
+	return results
 }
 
-func handleFSNotify(ws *Workspace, event *fsnotify.FileEvent) []*Event {
-	//fmt.Printf("%v\n", event)
-	events := []*Event{} // FIXME
-	defer func() {
-		if len(events) > 0 {
-			ws.Package.UpdateDepends()
+// runTests tests the updated packages, their reverse dependents if
+// TestReferrers is set, and the packages whose test files changed.
+func (w *Watcher) runTests(ctx context.Context, updated, tests []*Package, results map[*Package]*BuildResult) {
+	repo := w.Workspace.Package
+	targets := w.selected(append(repo.TestTargets(updated, w.TestReferrers), repo.TestTargets(tests, false)...))
+	tested := map[*Package]bool{}
+	tasks := []*Task{}
+	unlock := repo.rlock()
+	for _, pkg := range targets {
+		if r, found := results[pkg]; tested[pkg] || (found && r.Err != nil) {
+			continue
 		}
-	}()
-	path := event.Name
-	fi, fsErr := os.Stat(event.Name)
+		tested[pkg] = true
+		tasks = append(tasks, newJob(pkg, repo))
+	}
+	unlock()
+	for _, task := range tasks {
+		if ctx.Err() != nil {
+			return
+		}
+		w.observer.OnTestResult(task.TestContext(ctx))
+	}
+}
+
+// handleModuleEvent rereads the modules of the workspace when one of their
+// go.mod or the go.work changed, and rescans every package since they share
+// the build list. The packages of modules added to the go.work are found.
+// The repository must be locked.
+func handleModuleEvent(ws *Workspace, path string) []*Event {
+	events := []*Event{}
+	if !ws.ModuleMode() {
+		return events
+	}
+	dir := filepath.Clean(filepath.Dir(path))
+	relevant := filepath.Base(path) == WorkFileName && dir == filepath.Clean(ws.root)
+	for _, m := range ws.Modules {
+		relevant = relevant || filepath.Clean(m.Dir) == dir
+	}
+	if !relevant {
+		return events
+	}
+	// a half written file is read again on the next change
+	modules, err := ReadModules(ws.root)
+	if err != nil || len(modules) == 0 {
+		return events
+	}
+	known := map[string]bool{}
+	for _, m := range ws.Modules {
+		known[filepath.Clean(m.Dir)] = true
+	}
+	ws.Modules = modules
+	repo := ws.Package
+	for _, pkg := range repo.all() {
+		pkg.Module = ws.FindModule(pkg.WatchPath)
+		if pkg.Module == nil {
+			// dropped from the go.work
+			repo.delete(pkg)
+			events = append(events, &Event{Name: EventDelete, Pacakge: pkg})
+			continue
+		}
+		if err := ws.Scan(pkg); err != nil {
+			continue
+		}
+		repo.put(pkg)
+		events = append(events, &Event{Name: EventUpdate, Pacakge: pkg})
+	}
+	// the packages of the modules added to the go.work are found and watched
+	ws.Walk(func(path string) error {
+		m := ws.FindModule(path)
+		if m == nil || known[filepath.Clean(m.Dir)] || repo.findByPath(path) != nil {
+			return nil
+		}
+		pkg := ws.NewPackage(path)
+		if ws.Scan(pkg) != nil {
+			return nil
+		}
+		repo.put(pkg)
+		events = append(events, &Event{Name: EventFound, Pacakge: pkg}, &Event{Name: EventUpdate, Pacakge: pkg})
+		return nil
+	})
+	// replacements change where the imports outside of the repository are
+	repo.updateDepends()
+	return events
+}
+
+// handleFileEvent rescans the packages affected by the change of path with
+// the repository locked, returning the events of them.
+func handleFileEvent(ws *Workspace, path string) []*Event {
+	repo := ws.Package
+	repo.m.Lock()
+	defer repo.m.Unlock()
+	events := []*Event{} // FIXME
+	if name := filepath.Base(path); name == ModFileName || name == WorkFileName {
+		return handleModuleEvent(ws, path)
+	}
+	fi, fsErr := os.Stat(path)
 	if fsErr != nil || fi == nil {
-		if pkg := ws.Package.FindByPath(path); pkg != nil {
-			ws.Package.Delete(pkg)
+		if pkg := repo.findByPath(path); pkg != nil {
+			repo.delete(pkg)
 			events = append(events, &Event{Name: EventDelete, Pacakge: pkg})
-		} else if pkg := ws.Package.FindByPath(filepath.Dir(path)); pkg != nil {
-			pkg.Scan(ws.PackageRoot)
-			events = append(events, &Event{Name: EventUpdate, Pacakge: pkg})
+		} else if pkg := repo.findByPath(filepath.Dir(path)); pkg != nil {
+			ws.Scan(pkg)
+			repo.put(pkg)
+			name := EventUpdate
+			if IsGoTestSource(path) {
+				name = EventTestUpdate
+			}
+			events = append(events, &Event{Name: name, Pacakge: pkg})
 		}
 		return events
 	}
 	if fi.IsDir() {
 
-		ls := ws.Package.FindByDir(filepath.Dir(path))
+		ls := repo.findByDir(filepath.Dir(path))
 		for _, pkg := range ls {
 			if _, err := os.Stat(pkg.WatchPath); err != nil {
-				ws.Package.Delete(pkg)
+				repo.delete(pkg)
 				events = append(events, &Event{Name: EventDelete, Pacakge: pkg})
 			}
 		}
 
-	} else if IsGoSource(event.Name) {
+	} else if IsGoSource(path) {
 
 		path = filepath.Dir(path)
 
+	} else if IsGoTestSource(path) {
+
+		// only the package's tests are affected
+		pkg := repo.findByPath(filepath.Dir(path))
+		if pkg != nil && ws.Scan(pkg) == nil {
+			repo.put(pkg)
+			events = append(events, &Event{Name: EventTestUpdate, Pacakge: pkg})
+		}
+		return events
+
 	} else {
 		return events
 	}
 
-	pkg := ws.Package.FindByPath(path)
+	pkg := repo.findByPath(path)
 	found := pkg != nil
 	if !found {
 		pkg = ws.NewPackage(path)
 		events = append(events, &Event{Name: EventFound, Pacakge: pkg})
 	}
-	if err := pkg.Scan(ws.PackageRoot); err == SourceNotFound {
+	if err := ws.Scan(pkg); err == SourceNotFound {
 		if found {
-			ws.Package.Delete(pkg)
+			repo.delete(pkg)
 			events = append(events, &Event{Name: EventDelete, Pacakge: pkg})
 		}
 	} else {
 		//
-		ws.Package.Put(pkg)
+		repo.put(pkg)
 		events = append(events, &Event{Name: EventUpdate, Pacakge: pkg})
 	}
 
@@ -0,0 +1,496 @@
+package rbgo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTempModule writes a module with the package sources to a temporary
+// directory and returns the initialized workspace.
+func newTempModule(t *testing.T, sources map[string]string) *Workspace {
+	dir := t.TempDir()
+	sources["go.mod"] = "module example.com/temp\n\ngo 1.16\n"
+	for name, source := range sources {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := NewWorkspace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.CacheDir = ""
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+type cancelObserver struct {
+	NopObserver
+	cancel  context.CancelFunc
+	results []*BuildResult
+}
+
+func (c *cancelObserver) OnBuildResult(r *BuildResult) {
+	c.results = append(c.results, r)
+	c.cancel()
+}
+
+func TestWatcher_Watch_Cancel(t *testing.T) {
+	ws := newTempModule(t, map[string]string{
+		"hello/hello.go": "package hello\n\nfunc Hello() string { return \"hello\" }\n",
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &cancelObserver{cancel: cancel}
+	w := &Watcher{Workspace: ws, Observer: o, Log: ioutil.Discard}
+	done := make(chan error)
+	go func() {
+		done <- w.Watch(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("Watch didn't return")
+	}
+	if a, e := len(o.results), 1; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if err := o.results[0].Err; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWatcher_Watch_Select(t *testing.T) {
+	ws := newTempModule(t, map[string]string{
+		"hello/hello.go": "package hello\n\nimport \"example.com/temp/lib\"\n\nvar Hello = lib.Name\n",
+		"lib/lib.go":     "package lib\n\nconst Name = \"lib\"\n",
+		"other/other.go": "package other\n",
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &resultObserver{results: make(chan *BuildResult, 10)}
+	w := &Watcher{Workspace: ws, Observer: o, Log: ioutil.Discard, Select: func(pkg *Package) bool {
+		return pkg.FullName == "example.com/temp/hello"
+	}}
+	done := make(chan error)
+	go func() {
+		done <- w.Watch(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	// the selected package and its import
+	built := []string{}
+	for len(built) < 2 {
+		select {
+		case r := <-o.results:
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+			built = append(built, r.Task.PackageName)
+		case <-time.After(time.Minute):
+			t.Fatal("first build timeout")
+		}
+	}
+	if a, e := built, []string{"example.com/temp/lib", "example.com/temp/hello"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	other := ws.Package.FindByPath(filepath.Join(ws.root, "other"))
+	if _, err := os.Stat(other.ObjectPath); err == nil {
+		t.Errorf("unselected package built: `%s`", other.ObjectPath)
+	}
+}
+
+func TestScheduler_RunContext_Canceled(t *testing.T) {
+	repo, pkgs := newExampleRepository()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	plan, err := repo.BuildPlan(pkgs[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range (&Scheduler{Package: repo}).RunContext(ctx, plan) {
+		if r.Built {
+			os.Remove(r.Task.ObjectPath)
+			t.Errorf("unexpected build: `%s`", r.Task.PackageName)
+		}
+	}
+}
+
+type resultObserver struct {
+	NopObserver
+	results chan *BuildResult
+}
+
+func (o *resultObserver) OnBuildResult(r *BuildResult) {
+	o.results <- r
+}
+
+func TestWatcher_Watch_Restart(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks run by sh")
+	}
+	ws := newTempModule(t, map[string]string{
+		"hello/hello.go": "package hello\n\nfunc Hello() string { return \"hello\" }\n",
+	})
+	// the second build hangs in the hook until it's canceled
+	count := filepath.Join(ws.root, "count")
+	ws.Config = &Config{Hooks: HooksConfig{PreBuild: []string{
+		`n=$(cat count 2>/dev/null || echo 0); echo $((n+1)) > count; [ $n -ne 1 ] || exec sleep 60`,
+	}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &resultObserver{results: make(chan *BuildResult, 10)}
+	w := &Watcher{Workspace: ws, Observer: o, Log: ioutil.Discard, Debounce: 10 * time.Millisecond}
+	done := make(chan error)
+	go func() {
+		done <- w.Watch(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	wait := func(cond func() bool) {
+		for start := time.Now(); !cond(); time.Sleep(10 * time.Millisecond) {
+			if time.Since(start) > 30*time.Second {
+				t.Fatal("timeout")
+			}
+		}
+	}
+	select {
+	case r := <-o.results:
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("first build timeout")
+	}
+	source := filepath.Join(ws.root, "hello", "hello.go")
+	update := func(s string) {
+		data := "package hello\n\nfunc Hello() string { return \"" + s + "\" }\n"
+		if err := ioutil.WriteFile(source, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counted := func(n string) func() bool {
+		return func() bool {
+			data, _ := ioutil.ReadFile(count)
+			return string(data) == n+"\n"
+		}
+	}
+	update("hi")
+	wait(counted("2"))
+	start := time.Now()
+	update("hey")
+	select {
+	case r := <-o.results:
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("restarted build timeout")
+	}
+	if time.Since(start) > 30*time.Second {
+		t.Errorf("build not restarted")
+	}
+	if !counted("3")() {
+		t.Errorf("hook not run again")
+	}
+}
+
+func TestWatcher_Watch_Debounce(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks run by sh")
+	}
+	ws := newTempModule(t, map[string]string{
+		"hello/hello.go": "package hello\n\nfunc Hello() string { return \"hello\" }\n",
+		"other/other.go": "package other\n",
+	})
+	// the second build finishes while the change of other is still quiet
+	count := filepath.Join(ws.root, "count")
+	ws.Config = &Config{Hooks: HooksConfig{PreBuild: []string{
+		`n=$(cat count 2>/dev/null || echo 0); echo $((n+1)) > count; [ $n -ne 1 ] || sleep 0.5`,
+	}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &resultObserver{results: make(chan *BuildResult, 10)}
+	w := &Watcher{Workspace: ws, Observer: o, Log: ioutil.Discard, Debounce: 2 * time.Second}
+	done := make(chan error)
+	go func() {
+		done <- w.Watch(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	counted := func(n string) {
+		for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+			if data, _ := ioutil.ReadFile(count); string(data) == n+"\n" {
+				return
+			}
+			if time.Since(start) > 30*time.Second {
+				t.Fatal("timeout")
+			}
+		}
+	}
+	// the first build of both packages
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-o.results:
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+		case <-time.After(time.Minute):
+			t.Fatal("first build timeout")
+		}
+	}
+	counted("1")
+	update := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(ws.root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update("hello/hello.go", "package hello\n\nfunc Hello() string { return \"hi\" }\n")
+	counted("2")
+	start := time.Now()
+	update("other/other.go", "package other\n\nconst Other = 1\n")
+	counted("3")
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Errorf("built %v after the change, before its quiet period", elapsed)
+	}
+}
+
+func TestWatcher_Watch_Supervise(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals")
+	}
+	source := func(version string) string {
+		return "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\t\"time\"\n)\n\n" +
+			"const version = " + version + "\n\n" +
+			"func main() {\n\tos.WriteFile(os.Args[1], []byte(fmt.Sprint(version, \" \", os.Getpid())), 0644)\n\ttime.Sleep(time.Hour)\n}\n"
+	}
+	ws := newTempModule(t, map[string]string{
+		"server/main.go": source(`"v1"`),
+	})
+	marker := filepath.Join(t.TempDir(), "marker")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &resultObserver{results: make(chan *BuildResult, 10)}
+	w := &Watcher{
+		Workspace: ws,
+		Supervise: true,
+		Observer:  o,
+		Log:       ioutil.Discard,
+		Debounce:  10 * time.Millisecond,
+		Args:      map[string][]string{"example.com/temp/server": {marker}},
+	}
+	done := make(chan error)
+	go func() {
+		done <- w.Watch(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	// started waits for the binary of the version and returns its pid
+	started := func(version string) int {
+		for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+			data, _ := ioutil.ReadFile(marker)
+			var v string
+			var pid int
+			if _, err := fmt.Sscan(string(data), &v, &pid); err == nil && v == version {
+				return pid
+			}
+			if time.Since(start) > 30*time.Second {
+				t.Fatalf("%s not started: %q", version, data)
+			}
+		}
+	}
+	alive := func(pid int) bool {
+		p, err := os.FindProcess(pid)
+		return err == nil && p.Signal(syscall.Signal(0)) == nil
+	}
+	update := func(version string) {
+		path := filepath.Join(ws.root, "server", "main.go")
+		if err := ioutil.WriteFile(path, []byte(source(version)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first build starts the binary
+	v1 := started("v1")
+	// a rebuild restarts it
+	update(`"v2"`)
+	v2 := started("v2")
+	if v1 == v2 || alive(v1) {
+		t.Errorf("v1 still running: %d", v1)
+	}
+	// a failed rebuild keeps it running
+	update("undefined")
+	for failed := false; !failed; {
+		select {
+		case r := <-o.results:
+			failed = r.Err != nil
+		case <-time.After(30 * time.Second):
+			t.Fatal("failed build timeout")
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if a, e := started("v2"), v2; a != e || !alive(v2) {
+		err := "v2 stopped"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestHandleFileEvent_Concurrent(t *testing.T) {
+	ws := newTempModule(t, map[string]string{
+		"hello/hello.go":      "package hello\n\nfunc Hello() string { return \"hello\" }\n",
+		"hello/hello_test.go": "package hello\n",
+		"main.go":             "package main\n\nimport \"example.com/temp/hello\"\n\nfunc main() { hello.Hello() }\n",
+	})
+	dir := filepath.Join(ws.root, "hello")
+	source := filepath.Join(dir, "hello.go")
+	done := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			handleFileEvent(ws, source)
+			handleFileEvent(ws, filepath.Join(dir, "hello_test.go"))
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		repo := ws.Package
+		pkgs := repo.All()
+		if _, err := repo.BuildPlan(pkgs); err != nil {
+			t.Error(err)
+		}
+		repo.TestTargets(pkgs, true)
+		for _, pkg := range pkgs {
+			if task, err := (&TaskFactory{Package: repo}).New(pkg.WatchPath); err == nil {
+				task.InputHash()
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+	if ws.Package.FindByPath(dir) == nil {
+		t.Errorf("package lost: `%s`", dir)
+	}
+}
+
+func TestHandleFileEvent_Module(t *testing.T) {
+	ws := newTempModule(t, map[string]string{
+		"main.go": "package main\n\nimport \"example.org/lib\"\n\nfunc main() { lib.Hello() }\n",
+	})
+	lib := t.TempDir()
+	for name, source := range map[string]string{
+		"go.mod": "module example.org/lib\n\ngo 1.16\n",
+		"lib.go": "package lib\n\nfunc Hello() {}\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(lib, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	main := ws.Package.FindByPath(ws.root)
+	if a, e := main.MissingImports, []string{"example.org/lib"}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	mod := filepath.Join(ws.root, ModFileName)
+	source := "module example.com/temp\n\ngo 1.16\n\nrequire example.org/lib v0.0.0\n\nreplace example.org/lib => " + lib + "\n"
+	if err := ioutil.WriteFile(mod, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	events := handleFileEvent(ws, mod)
+	if a, e := len(events), 1; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := events[0].Name, EventUpdate; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := ws.FindModule(ws.root).Replace[0].Dir, lib; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := len(main.MissingImports), 0; a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestHandleFileEvent_WorkUse(t *testing.T) {
+	dir := t.TempDir()
+	for name, source := range map[string]string{
+		"go.work":  "go 1.18\n\nuse ./a\n",
+		"a/go.mod": "module example.com/a\n\ngo 1.18\n",
+		"a/a.go":   "package a\n",
+		"b/go.mod": "module example.com/b\n\ngo 1.18\n",
+		"b/b.go":   "package b\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws, err := NewWorkspace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.CacheDir = ""
+	if err := ws.Init(); err != nil {
+		t.Fatal(err)
+	}
+	b := filepath.Join(dir, "b")
+	if ws.Package.FindByPath(b) != nil {
+		t.Fatal("package of an unused module")
+	}
+	work := filepath.Join(dir, WorkFileName)
+	if err := ioutil.WriteFile(work, []byte("go 1.18\n\nuse (\n\t./a\n\t./b\n)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	found := []string{}
+	for _, e := range handleFileEvent(ws, work) {
+		if e.Name == EventFound {
+			found = append(found, e.Pacakge.WatchPath)
+		}
+	}
+	if a, e := found, []string{b}; !reflect.DeepEqual(a, e) {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if pkg := ws.Package.FindByPath(b); pkg == nil || pkg.FullName != "example.com/b" {
+		t.Errorf("package not found: `%s`", b)
+	}
+}
@@ -6,37 +6,165 @@ import (
 	"fmt"
 	"strings"
 	"regexp"
+	"runtime"
+	"sync"
 )
 
 // Workspace
 type Workspace struct {
+	root        string
 	sourceEntry string
 	objectPath  string
 	ExcludeDirs ExcludeDirs
 	PackageRoot PackageRootFinder
 	Package     *PackageRepository
+	Modules     []*Module
+	Binaries    map[string]string
+	CacheDir    string
+	Options     BuildOptions
+	// PackageOptions add to Options for the package of the full name.
+	PackageOptions map[string]*BuildOptions
+	// Platforms are the targets the packages are built for, the default
+	// platform if empty.
+	Platforms   []Platform
+	Config      *Config
+	// ScanErrors are the packages Init failed to scan.
+	ScanErrors  []*ScanError
+}
+
+// ScanError is the error scanning the package in Path.
+type ScanError struct {
+	Path string
+	Err  error
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
 }
 
 func NewWorkspace(path string) (*Workspace, error) {
+	return NewWorkspaceConfig(path, "")
+}
+
+// NewWorkspaceConfig is NewWorkspace with the config file read instead of the
+// one in the workspace root, if config isn't empty.
+func NewWorkspaceConfig(path, config string) (*Workspace, error) {
 	w := &Workspace{
 		ExcludeDirs: ExcludeDirs([]string{".git", ".idea"}),
 		PackageRoot: PackageRootFinder([]*regexp.Regexp{}),
 		Package: new(PackageRepository).Init(),
+		Binaries: map[string]string{},
+		PackageOptions: map[string]*BuildOptions{},
+		CacheDir: DefaultCacheDir(),
 	}
 	_, err := os.Stat(path)
 	if err != nil {
 		return nil, err
 	}
+	w.root = path
 	w.sourceEntry = path
 	//w.objectPath = filepath.Join(path, "pkg")
-	s := filepath.Join(path, "src")
-	if _, err = os.Stat(s); err == nil {
-		w.sourceEntry = s
+	if w.Modules, err = ReadModules(path); err != nil {
+		return nil, err
+	}
+	if !w.ModuleMode() {
+		s := filepath.Join(path, "src")
+		if _, err = os.Stat(s); err == nil {
+			w.sourceEntry = s
+		}
 	}
 	w.AddPackageRoot("golang.org/x/[a-zA-Z0-9_-]+")
 	w.AddPackageRoot("github.com/[a-zA-Z0-9_-]+/[a-zA-Z0-9_-]+")
 	w.AddPackageRoot("bitbucket.org/[a-zA-Z0-9_-]+/[a-zA-Z0-9_-]+")
-	return w, err
+	if config == "" {
+		config, _ = FindConfig(path)
+	}
+	if config != "" {
+		if err := w.LoadConfig(config); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// LoadConfig reads the config file and applies it to the workspace.
+func (w *Workspace) LoadConfig(path string) error {
+	c, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	w.ApplyConfig(c)
+	return nil
+}
+
+func (w *Workspace) ApplyConfig(c *Config) {
+	w.ExcludeDirs = append(w.ExcludeDirs, c.Exclude...)
+	// configured roots take precedence over the defaults
+	roots := PackageRootFinder([]*regexp.Regexp{})
+	for _, root := range c.PackageRoots {
+		roots = append(roots, regexp.MustCompile(root))
+	}
+	w.PackageRoot = append(roots, w.PackageRoot...)
+	for _, target := range c.Targets {
+		if target.Output != "" {
+			w.SetBinaryPath(target.Package, target.Output)
+		}
+	}
+	if w.PackageOptions == nil {
+		w.PackageOptions = map[string]*BuildOptions{}
+	}
+	for _, target := range c.Targets {
+		if !target.Build.isZero() {
+			options := target.Build.Options()
+			w.PackageOptions[target.Package] = &options
+		}
+	}
+	options := c.Build.Options()
+	w.Options = w.Options.Merge(&options)
+	if w.Options.Env == nil {
+		w.Options.Env = map[string]string{}
+	}
+	for key, value := range c.Env {
+		w.Options.Env[key] = value
+	}
+	w.Platforms = append(w.Platforms, c.TargetPlatforms()...)
+	if c.CacheDir != "" {
+		w.SetCacheDir(c.CacheDir)
+	}
+	w.Config = c
+}
+
+// SetCacheDir sets the build cache directory, relative to the workspace
+// root. CacheOff disables the cache.
+func (w *Workspace) SetCacheDir(dir string) {
+	switch {
+	case dir == CacheOff:
+		w.CacheDir = ""
+	case filepath.IsAbs(dir):
+		w.CacheDir = dir
+	default:
+		w.CacheDir = filepath.Join(w.root, dir)
+	}
+}
+
+// ModuleMode reports whether the workspace is a go.mod or go.work tree rather
+// than a GOPATH layout.
+func (w *Workspace) ModuleMode() bool {
+	return len(w.Modules) > 0
+}
+
+// FindModule returns the innermost module containing the directory.
+func (w *Workspace) FindModule(path string) *Module {
+	var found *Module
+	for _, m := range w.Modules {
+		if !m.Contains(path) {
+			continue
+		}
+		if found == nil || len(found.Dir) < len(m.Dir) {
+			found = m
+		}
+	}
+	return found
 }
 
 func (w *Workspace) AddPackageRoot(str string) {
@@ -51,38 +179,141 @@ func (w *Workspace) Walk(f func(string) error) error {
 		if w.ExcludeDirs.Contains(path) {
 			return nil
 		}
+		if w.ModuleMode() {
+			m := w.FindModule(path)
+			if m == nil {
+				return nil
+			}
+			// nested modules outside of go.work are not part of the workspace
+			if _, err := os.Stat(filepath.Join(path, ModFileName)); err == nil && filepath.Clean(path) != filepath.Clean(m.Dir) {
+				return filepath.SkipDir
+			}
+		}
 		return f(path)
 	})
 }
 
 func (w *Workspace) NewPackage(path string) *Package {
-	return NewPackage(w.sourceEntry, path)
+	pkg := NewPackage(w.sourceEntry, path)
+	pkg.Module = w.FindModule(path)
+	return pkg
 }
 
-func (w *Workspace) Init() error {
-	err := w.Walk(func(path string) error {
-		pkg := w.Package.FindByPath(path)
-		if pkg == nil {
-			pkg = w.NewPackage(path)
+// SetBinaryPath sets the output path of the command with the import path,
+// relative paths are resolved against the workspace root.
+func (w *Workspace) SetBinaryPath(fullName, output string) {
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(w.root, output)
+	}
+	w.Binaries[fullName] = output
+}
+
+// Scan scans the package under the build constraints of its options and
+// applies its output settings.
+func (w *Workspace) Scan(pkg *Package) error {
+	pkg.BuildContext = w.Options.BuildContext()
+	if err := pkg.Scan(w.PackageRoot); err != nil {
+		return err
+	}
+	pkg.Options = &w.Options
+	if p, found := w.PackageOptions[pkg.FullName]; found {
+		// the full name is known after the scan, rescan with the package's tags
+		options := w.Options.Merge(p)
+		pkg.BuildContext = options.BuildContext()
+		if err := pkg.Scan(w.PackageRoot); err != nil {
+			return err
 		}
-		err := pkg.Scan(w.PackageRoot)
+		pkg.Options = &options
+	}
+	if output, found := w.Binaries[pkg.FullName]; found && pkg.IsCommand {
+		pkg.ObjectPath = output
+	}
+	return nil
+}
+
+// ManifestPath returns where the input hashes of the workspace's objects are
+// recorded.
+func (w *Workspace) ManifestPath() string {
+	return filepath.Join(w.root, PackageDirName, ManifestFileName)
+}
+
+func (w *Workspace) Init() error {
+	manifest, err := LoadManifest(w.ManifestPath())
+	if err != nil {
+		return err
+	}
+	w.Package.m.Lock()
+	w.Package.Manifest = manifest
+	// an empty CacheDir disables the build cache
+	w.Package.Cache = nil
+	if w.CacheDir != "" {
+		w.Package.Cache = &Cache{Dir: w.CacheDir}
+	}
+	w.Package.m.Unlock()
+	paths := []string{}
+	err = w.Walk(func(path string) error {
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	pkgs, errs := w.scanAll(paths)
+	w.ScanErrors = []*ScanError{}
+	for i, pkg := range pkgs {
+		err := errs[i]
 		if err == nil {
 			w.Package.Put(pkg)
-		//	fmt.Printf("import: %s\n", pkg.FullName)
-		//} else if err == SourceNotFound {
-		//	fmt.Printf("SourceNotFound: %s, %v\n", pkg.WatchPath, err)
 		} else if err != SourceNotFound {
-			fmt.Printf("Error: %s, %v\n", pkg.WatchPath, err)
+			w.ScanErrors = append(w.ScanErrors, &ScanError{Path: pkg.WatchPath, Err: err})
 		}
-		return nil
-	})
-	if err != nil {
+	}
+	return nil
+}
+
+// rescan scans a package of the repository with it locked, so its readers
+// never see it half scanned.
+func (w *Workspace) rescan(pkg *Package) error {
+	w.Package.m.Lock()
+	defer w.Package.m.Unlock()
+	if err := w.Scan(pkg); err != nil {
 		return err
 	}
-	w.Package.UpdateDepends()
+	w.Package.put(pkg)
 	return nil
 }
 
+// scanAll scans the packages of the directories with a worker per CPU,
+// returning them and the errors in the order of paths.
+func (w *Workspace) scanAll(paths []string) ([]*Package, []error) {
+	pkgs := make([]*Package, len(paths))
+	errs := make([]error, len(paths))
+	jobs := make(chan int)
+	wg := new(sync.WaitGroup)
+	for n := runtime.GOMAXPROCS(0); n > 0; n-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				pkg := w.Package.FindByPath(paths[i])
+				if pkg == nil {
+					pkg = w.NewPackage(paths[i])
+					errs[i] = w.Scan(pkg)
+				} else {
+					errs[i] = w.rescan(pkg)
+				}
+				pkgs[i] = pkg
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return pkgs, errs
+}
+
 // ExcludeDirs
 type ExcludeDirs []string
 
@@ -1,6 +1,11 @@
 package rbgo
 
-import "testing"
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
 
 func TestPackageRootFinder_Find(t *testing.T) {
 	w, _ := NewWorkspace(".")
@@ -77,6 +82,53 @@ func TestNewWorkDir_StandardLayout(t *testing.T) {
 	//}
 }
 //
+func TestWorkspace_Init(t *testing.T) {
+	w, err := NewWorkspace("../example/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.CacheDir = ""
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, pkg := range w.Package.All() {
+		actual = append(actual, pkg.FullName)
+	}
+	sort.Strings(actual)
+	// the packages scanned one by one
+	expect := []string{}
+	w.Walk(func(path string) error {
+		if pkg := w.NewPackage(path); w.Scan(pkg) == nil {
+			expect = append(expect, pkg.FullName)
+		}
+		return nil
+	})
+	sort.Strings(expect)
+	if a, e := actual, expect; !reflect.DeepEqual(a, e) || len(a) == 0 {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+}
+
+func TestWorkspace_Init_ScanErrors(t *testing.T) {
+	ws := newTempModule(t, map[string]string{
+		"hello/hello.go": "package hello\n",
+		"broken/b.go":    "package broken\n\nimport (\"fmt\"\n",
+	})
+	if a, e := len(ws.ScanErrors), 1; a != e {
+		err := "mismatch"
+		t.Fatalf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if a, e := ws.ScanErrors[0].Path, filepath.Join(ws.root, "broken"); a != e {
+		err := "mismatch"
+		t.Errorf("%s\nactual: %v\nexpect: %v", err, a, e)
+	}
+	if ws.Package.FindByPath(filepath.Join(ws.root, "hello")) == nil {
+		t.Error("package not found: hello")
+	}
+}
+
 //func TestWorkDir_Init(t *testing.T) {
 //	w, _ := NewWorkDir("../example")
 //	w.Init()
@@ -97,3 +149,40 @@ func TestNewWorkDir_StandardLayout(t *testing.T) {
 //		}
 //	}
 //}
+
+func TestWorkspace_Init_Concurrent(t *testing.T) {
+	ws := newTempModule(t, map[string]string{
+		"hello/hello.go": "package hello\n\nfunc Hello() string { return \"hello\" }\n",
+		"main.go":        "package main\n\nimport \"example.com/temp/hello\"\n\nfunc main() { hello.Hello() }\n",
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			if err := ws.Init(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	factory := &TaskFactory{Package: ws.Package}
+	for {
+		select {
+		case <-done:
+			if ws.Package.FindByPath(filepath.Join(ws.root, "hello")) == nil {
+				t.Error("package lost: hello")
+			}
+			return
+		default:
+		}
+		pkgs := ws.Package.All()
+		if _, err := ws.Package.BuildPlan(pkgs); err != nil {
+			t.Error(err)
+		}
+		for _, pkg := range pkgs {
+			if task, err := factory.New(pkg.WatchPath); err == nil {
+				task.InputHash()
+				task.Stale()
+			}
+		}
+	}
+}